@@ -0,0 +1,230 @@
+//apidiff replays captured requests against two running servers and reports where their responses differ
+//Use it to check a refactor or a version upgrade preserves behavior, e.g. the old and the new build side by side:
+//
+//	apidiff -a http://localhost:3000 -b http://localhost:3001 requests.jsonl
+//
+//Each line of the capture file is one request: {"method":"GET","path":"/v1/books?page=2","headers":{...},"body":"..."}
+//method defaults to GET; body is sent as a form. Requests that change data should only be replayed against
+//servers with databases of their own, since both servers get every request
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+//One captured request
+type capturedRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+//What we compare of a response
+type response struct {
+	status      int
+	contentType string
+	body        []byte
+}
+
+func main() {
+	a := flag.String("a", "", "base URL of the first server, e.g. http://localhost:3000")
+	b := flag.String("b", "", "base URL of the second server")
+	//Each server assigns its own ids and timestamps, so by default those aren't compared
+	ignore := flag.String("ignore", "request_id,id,created_at,updated_at", "comma-separated JSON field names to leave out of the comparison, at any depth")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout for each request")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: apidiff -a URL -b URL [flags] [capture.jsonl]\nreads the capture from stdin when no file is given\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *a == "" || *b == "" || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	in := os.Stdin
+	if flag.NArg() == 1 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	ignored := make(map[string]bool)
+	for _, name := range strings.Split(*ignore, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ignored[name] = true
+		}
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	var total, differing int
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var req capturedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			log.Fatalf("line %d: %v", line, err)
+		}
+		if req.Method == "" {
+			req.Method = "GET"
+		}
+		total++
+
+		ra, errA := replay(client, *a, req)
+		rb, errB := replay(client, *b, req)
+		var diffs []string
+		switch {
+		case errA != nil || errB != nil:
+			diffs = []string{fmt.Sprintf("request failed: a: %v, b: %v", errA, errB)}
+		default:
+			diffs = compare(ra, rb, ignored)
+		}
+
+		if len(diffs) > 0 {
+			differing++
+			fmt.Printf("line %d: %s %s\n", line, req.Method, req.Path)
+			for _, d := range diffs {
+				fmt.Printf("  %s\n", d)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%d requests, %d with differences\n", total, differing)
+	if differing > 0 {
+		os.Exit(1)
+	}
+}
+
+//Send req to the server at base and read the whole response
+func replay(client *http.Client, base string, req capturedRequest) (*response, error) {
+	var body io.Reader
+	if req.Body != "" {
+		body = strings.NewReader(req.Body)
+	}
+	r, err := http.NewRequest(req.Method, strings.TrimSuffix(base, "/")+req.Path, body)
+	if err != nil {
+		return nil, err
+	}
+	if req.Body != "" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for k, v := range req.Headers {
+		r.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &response{status: resp.StatusCode, contentType: resp.Header.Get("Content-Type"), body: b}, nil
+}
+
+//The differences between two responses, as lines for the report
+//JSON bodies are compared as values, so key order and whitespace don't count; anything else must match byte for byte
+func compare(a, b *response, ignored map[string]bool) []string {
+	var diffs []string
+	if a.status != b.status {
+		diffs = append(diffs, fmt.Sprintf("status: %d != %d", a.status, b.status))
+	}
+	if a.contentType != b.contentType {
+		diffs = append(diffs, fmt.Sprintf("content type: %q != %q", a.contentType, b.contentType))
+	}
+
+	var va, vb interface{}
+	if json.Unmarshal(a.body, &va) != nil || json.Unmarshal(b.body, &vb) != nil {
+		if !bytes.Equal(a.body, b.body) {
+			diffs = append(diffs, fmt.Sprintf("body: %d bytes != %d bytes", len(a.body), len(b.body)))
+		}
+		return diffs
+	}
+	return append(diffs, diffJSON("$", va, vb, ignored)...)
+}
+
+//Walk two decoded JSON values side by side and describe every place they differ, e.g. $.books[3].price: 5.9 != 6.5
+func diffJSON(path string, a, b interface{}, ignored map[string]bool) []string {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		//Keys in sorted order, so the report is the same from one run to the next
+		keys := make([]string, 0, len(a)+len(b))
+		for k := range a {
+			keys = append(keys, k)
+		}
+		for k := range b {
+			if _, ok := a[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		var diffs []string
+		for _, k := range keys {
+			va, inA := a[k]
+			vb, inB := b[k]
+			switch {
+			case ignored[k]:
+			case !inB:
+				diffs = append(diffs, fmt.Sprintf("%s.%s: only in a", path, k))
+			case !inA:
+				diffs = append(diffs, fmt.Sprintf("%s.%s: only in b", path, k))
+			default:
+				diffs = append(diffs, diffJSON(path+"."+k, va, vb, ignored)...)
+			}
+		}
+		return diffs
+
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		var diffs []string
+		if len(a) != len(b) {
+			diffs = append(diffs, fmt.Sprintf("%s: %d elements != %d elements", path, len(a), len(b)))
+		}
+		for i := 0; i < len(a) && i < len(b); i++ {
+			diffs = append(diffs, diffJSON(fmt.Sprintf("%s[%d]", path, i), a[i], b[i], ignored)...)
+		}
+		return diffs
+
+	default:
+		if a == b {
+			return nil
+		}
+	}
+
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return []string{fmt.Sprintf("%s: %s != %s", path, ja, jb)}
+}
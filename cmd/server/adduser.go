@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/osmumos/bookstore/internal/models"
+	"github.com/osmumos/bookstore/internal/store"
+)
+
+const adduserUsage = `usage: bookstore adduser <username> [admin | clerk | reader]
+  the role defaults to reader. Reads the password from the first line of stdin, e.g.
+  printf '%s\n' "$PASSWORD" | bookstore adduser alice clerk`
+
+//Run the adduser subcommand
+//The password comes from stdin rather than the command line, where it would show up in ps and shell history
+func adduser(st *store.Store, args []string) error {
+	if len(args) < 1 || len(args) > 2 || args[0] == "" {
+		return fmt.Errorf("%s", adduserUsage)
+	}
+	role := models.RoleReader
+	if len(args) == 2 {
+		role = args[1]
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return fmt.Errorf("adduser: no password on stdin (%v)\n%s", err, adduserUsage)
+	}
+
+	//bcrypt ignores everything past 72 bytes, so longer passwords would be silently truncated
+	if len(password) > 72 {
+		return fmt.Errorf("adduser: password must be at most 72 bytes")
+	}
+
+	u, err := st.CreateUser(context.Background(), args[0], password, role)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("added %s %s (%s)\n", u.Role, u.Username, u.ID)
+	return nil
+}
@@ -0,0 +1,160 @@
+/*
+** Tutorial on how to Access DB in GO using database/sql package
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/osmumos/bookstore/internal/config"
+	"github.com/osmumos/bookstore/internal/grpcserver"
+	"github.com/osmumos/bookstore/internal/handlers"
+	"github.com/osmumos/bookstore/internal/store"
+	"github.com/osmumos/bookstore/internal/systemd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func main() {
+	//The connection string and pool settings come from the environment, see internal/config
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//Open the pool and wait for the database to answer, see internal/store
+	st, err := store.Open(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//Subcommands (bookstore migrate ..., bookstore adduser ...) do their job and exit instead of serving
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "migrate":
+			err = migrate(st, os.Args[2:])
+		case "adduser":
+			err = adduser(st, os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command %q\n%s\n%s", os.Args[1], migrateUsage, adduserUsage)
+		}
+		st.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := cfg.ValidateServe(); err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.MigrateOnStart {
+		if _, err := st.MigrateUp(context.Background()); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	h := handlers.New(st, st.Books(), cfg)
+
+	//Start the HTTP Server
+	ln, err := systemd.Listen(cfg.ListenAddr, cfg.UnixSocket)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tlsCfg, err := tlsConfig(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &http.Server{
+		TLSConfig:         tlsCfg,
+		Handler:           handlers.AccessLog(h.Routes(), cfg.AccessLogFormat, cfg.AccessLogFile),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+
+	//Serve in the background so main can wait for a stop signal
+	serveErr := make(chan error, 2)
+	go func() {
+		if tlsCfg != nil {
+			serveErr <- srv.ServeTLS(ln, "", "")
+		} else {
+			serveErr <- srv.Serve(ln)
+		}
+	}()
+
+	//The gRPC BookService gets its own port, sharing the store with the HTTP API
+	var gs *grpc.Server
+	if cfg.GRPCAddr != "" {
+		gln, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var opts []grpc.ServerOption
+		if tlsCfg != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+		}
+		gs = grpcserver.New(st, st.Books(), cfg, opts...)
+		go func() { serveErr <- gs.Serve(gln) }()
+	}
+
+	//Tell systemd we are up (no-op when not running under systemd) and keep its watchdog fed
+	systemd.Notify("READY=1")
+	go systemd.Watchdog()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case sig := <-stop:
+		log.Printf("received %s, shutting down", sig)
+	}
+
+	//Stop accepting connections and give in-flight requests until the deadline to finish
+	//Only then close the pool, so no request loses its connection halfway through
+	systemd.Notify("STOPPING=1")
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	if gs != nil {
+		stopGRPC(ctx, gs)
+	}
+	if err := st.Close(); err != nil {
+		log.Printf("closing database: %v", err)
+	}
+}
+
+//Let in-flight gRPC calls finish, cutting them off if ctx expires first
+func stopGRPC(ctx context.Context, gs *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Printf("grpc shutdown: %v", ctx.Err())
+		gs.Stop()
+	}
+}
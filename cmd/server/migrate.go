@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/osmumos/bookstore/internal/store"
+)
+
+const migrateUsage = `usage: bookstore migrate [up | down [N] | status]
+  up      apply every pending migration (the default)
+  down N  roll back the newest N applied migrations (default 1)
+  status  list migrations and when each was applied`
+
+//Run the migrate subcommand, e.g. bookstore migrate down 2
+//Migrations are not bound by REQUEST_TIMEOUT: creating an index on a big table can take a while
+func migrate(st *store.Store, args []string) error {
+	ctx := context.Background()
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch {
+	case cmd == "up" && len(args) == 0:
+		done, err := st.MigrateUp(ctx)
+		if err == nil && len(done) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+
+	case cmd == "down" && len(args) <= 1:
+		steps := 1
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return fmt.Errorf("migrate down: %q is not a positive number\n%s", args[0], migrateUsage)
+			}
+			steps = n
+		}
+		_, err := st.MigrateDown(ctx, steps)
+		return err
+
+	case cmd == "status" && len(args) == 0:
+		status, err := st.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range status {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Format("2006-01-02 15:04:05Z07:00")
+			}
+			fmt.Fprintf(os.Stdout, "%04d  %-32s %s\n", m.Version, m.Name, applied)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%s", migrateUsage)
+}
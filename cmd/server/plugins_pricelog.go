@@ -0,0 +1,7 @@
+//go:build pricelog
+
+package main
+
+//Plugins are enabled by importing them. Each one gets a file like this, selected with a build tag:
+//go build -tags pricelog ./cmd/server
+import _ "github.com/osmumos/bookstore/plugins/pricelog"
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/tls"
+
+	"github.com/osmumos/bookstore/internal/config"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+//The TLS settings for the HTTP and gRPC servers, or nil to serve plain text
+//Certificate files are loaded here, so a bad path fails at startup rather than on the first handshake; restart to pick up
+//a renewed certificate. With autocert, Let's Encrypt issues and renews certificates for cfg.AutocertDomains through the
+//TLS-ALPN-01 challenge, which only works when the server is reachable on port 443
+func tlsConfig(cfg *config.Config) (*tls.Config, error) {
+	switch {
+	case cfg.TLSCertFile != "":
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+
+	case len(cfg.AutocertDomains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		c := m.TLSConfig()
+		c.MinVersion = tls.VersionTLS12
+		return c, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,282 @@
+//Package grpcserver serves the BookService of api/bookstore/v1 on top of the same store as the HTTP API
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"runtime/debug"
+	"time"
+
+	bookstorev1 "github.com/osmumos/bookstore/api/bookstore/v1"
+	"github.com/osmumos/bookstore/internal/config"
+	"github.com/osmumos/bookstore/internal/hooks"
+	"github.com/osmumos/bookstore/internal/models"
+	"github.com/osmumos/bookstore/internal/requestid"
+	"github.com/osmumos/bookstore/internal/store"
+	"github.com/osmumos/bookstore/internal/validate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+//Methods that change the catalog, and so need an API key for one of catalogWriters
+var (
+	writeMethods = map[string]bool{
+		bookstorev1.BookService_Create_FullMethodName: true,
+		bookstorev1.BookService_Update_FullMethodName: true,
+		bookstorev1.BookService_Delete_FullMethodName: true,
+	}
+	catalogWriters = []string{models.RoleAdmin, models.RoleClerk}
+)
+
+//Implements bookstorev1.BookServiceServer
+type Server struct {
+	bookstorev1.UnimplementedBookServiceServer
+
+	store *store.Store
+	books store.BookStore
+	hooks *hooks.Registry
+}
+
+//Create a gRPC server for books, with API keys checked against s. opts are extra server options, such as TLS credentials
+//Calls get the same deadline, plugin hooks and SQL route tags as HTTP requests
+func New(s *store.Store, books store.BookStore, cfg *config.Config, opts ...grpc.ServerOption) *grpc.Server {
+	srv := &Server{store: s, books: books, hooks: hooks.Default}
+
+	//withBudget comes before requireWriter, so the API key lookup runs under the deadline too
+	opts = append(opts, grpc.ChainUnaryInterceptor(withRequestID, recoverPanics, withBudget(cfg), srv.requireWriter))
+	gs := grpc.NewServer(opts...)
+	bookstorev1.RegisterBookServiceServer(gs, srv)
+	return gs
+}
+
+//Give every call an ID, or keep the one sent in the x-request-id metadata, and send it back in the response header
+func withRequestID(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var id string
+	if ids := metadata.ValueFromIncomingContext(ctx, requestid.Header); len(ids) == 1 {
+		id = ids[0]
+	}
+	id = requestid.Use(id)
+
+	grpc.SetHeader(ctx, metadata.Pairs(requestid.Header, id))
+	return handler(requestid.With(ctx, id), req)
+}
+
+//Turn a panic in a handler into a logged stack trace and an Internal error
+//Unlike net/http, grpc-go doesn't recover handler panics, so without this one would take the whole server down
+func recoverPanics(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logCall(ctx, info.FullMethod, "panic: %v\n%s", p, debug.Stack())
+			err = status.Errorf(codes.Internal, "internal server error (request_id=%s)", requestid.From(ctx))
+		}
+	}()
+	return handler(ctx, req)
+}
+
+//Give each call the request deadline (or the client's, if sooner) and tag its SQL with the method name
+func withBudget(cfg *config.Config) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, cfg.RequestTimeout)
+		defer cancel()
+		return handler(store.WithRoute(ctx, info.FullMethod), req)
+	}
+}
+
+//Only let write calls through with an API key for a clerk or admin in the x-api-key metadata
+//A missing or bad key is Unauthenticated, a valid key with another role PermissionDenied
+func (s *Server) requireWriter(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !writeMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+
+	keys := metadata.ValueFromIncomingContext(ctx, "x-api-key")
+	if len(keys) != 1 || keys[0] == "" {
+		return nil, status.Error(codes.Unauthenticated, "x-api-key metadata is required")
+	}
+	k, err := s.store.AuthenticateAPIKey(ctx, keys[0])
+	if err == store.ErrInvalidCredentials {
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	} else if err != nil {
+		return nil, serverError(ctx, info.FullMethod, err)
+	}
+
+	for _, role := range catalogWriters {
+		if k.Role == role {
+			return handler(ctx, req)
+		}
+	}
+	return nil, status.Error(codes.PermissionDenied, "the API key's role may not change the catalog")
+}
+
+//The gRPC counterpart of the HTTP invalid_params: InvalidArgument, with a BadRequest detail naming each bad field
+func invalid(errs validate.Errors) error {
+	br := &errdetails.BadRequest{}
+	for _, fe := range errs {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: fe.Name, Description: fe.Reason})
+	}
+	st, err := status.New(codes.InvalidArgument, errs.Error()).WithDetails(br)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, errs.Error())
+	}
+	return st.Err()
+}
+
+//The gRPC counterpart of the HTTP serverError: log the error, and tell the client only that it happened
+//If the deadline ran out the client gets DeadlineExceeded (or Canceled) instead, since retrying may well succeed
+//The message carries the request ID, so a report can be matched with the log line
+func serverError(ctx context.Context, method string, err error) error {
+	if ctx.Err() != nil {
+		logCall(ctx, method, "request budget exceeded: %v", err)
+		return status.FromContextError(ctx.Err()).Err()
+	}
+
+	logCall(ctx, method, "%v", err)
+	return status.Errorf(codes.Internal, "internal server error (request_id=%s)", requestid.From(ctx))
+}
+
+//Log a message about a call, prefixed with its method and request ID
+func logCall(ctx context.Context, method, format string, args ...interface{}) {
+	prefix := []interface{}{method, requestid.From(ctx)}
+	log.Printf("%s request_id=%s: "+format, append(prefix, args...)...)
+}
+
+func toProto(bk *models.Book) *bookstorev1.Book {
+	return &bookstorev1.Book{
+		Id:        bk.ID,
+		Isbn:      bk.ISBN,
+		Title:     bk.Title,
+		Author:    bk.Author,
+		Price:     bk.Price,
+		CreatedAt: timestamppb.New(bk.CreatedAt),
+		UpdatedAt: timestamppb.New(bk.UpdatedAt),
+	}
+}
+
+//An unset timestamp is the zero time, which the store treats as no filter
+func asTime(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+//List books a page at a time, filtered like GET /v1/books and /v1/books/search combined
+func (s *Server) List(ctx context.Context, req *bookstorev1.ListRequest) (*bookstorev1.ListResponse, error) {
+	//Unset page and per_page are 0, which Paging takes as not given
+	page, perPage, err := store.Paging(int(req.GetPage()), int(req.GetPerPage()))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	f := models.BookFilter{Title: req.GetTitle(), Author: req.GetAuthor(), MinPrice: req.MinPrice, MaxPrice: req.MaxPrice}
+	f.CreatedAfter, f.CreatedBefore = asTime(req.CreatedAfter), asTime(req.CreatedBefore)
+	f.UpdatedAfter, f.UpdatedBefore = asTime(req.UpdatedAfter), asTime(req.UpdatedBefore)
+	for _, p := range []*float64{f.MinPrice, f.MaxPrice} {
+		if p != nil && (math.IsNaN(*p) || math.IsInf(*p, 0) || *p < 0) {
+			return nil, status.Error(codes.InvalidArgument, "prices must be non-negative numbers")
+		}
+	}
+
+	bp, err := s.books.List(ctx, f, page, perPage)
+	if err != nil {
+		return nil, serverError(ctx, bookstorev1.BookService_List_FullMethodName, err)
+	}
+
+	resp := &bookstorev1.ListResponse{Page: int32(bp.Page), PerPage: int32(bp.PerPage), Total: int32(bp.Total)}
+	for _, bk := range bp.Books {
+		resp.Books = append(resp.Books, toProto(bk))
+	}
+	return resp, nil
+}
+
+//Fetch one book by ISBN or UUID, optionally as it was at as_of
+func (s *Server) Get(ctx context.Context, req *bookstorev1.GetRequest) (*bookstorev1.Book, error) {
+	if req.GetRef() == "" {
+		return nil, status.Error(codes.InvalidArgument, "ref is required")
+	}
+
+	bk, err := s.books.Get(ctx, req.GetRef(), asTime(req.AsOf))
+	if err == store.ErrNotFound {
+		return nil, status.Error(codes.NotFound, "no such book")
+	} else if err != nil {
+		return nil, serverError(ctx, bookstorev1.BookService_Get_FullMethodName, err)
+	}
+	return toProto(bk), nil
+}
+
+//Create a book, running the same plugin hooks as POST /v1/books
+func (s *Server) Create(ctx context.Context, req *bookstorev1.CreateRequest) (*bookstorev1.Book, error) {
+	in := &models.Book{ISBN: req.GetIsbn(), Title: req.GetTitle(), Author: req.GetAuthor(), Price: req.GetPrice()}
+	if errs := validate.Book(in, true); len(errs) > 0 {
+		return nil, invalid(errs)
+	}
+
+	if err := s.hooks.BeforeBookCreate(ctx, in); errors.Is(err, hooks.ErrRejected) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	} else if err != nil {
+		return nil, serverError(ctx, bookstorev1.BookService_Create_FullMethodName, err)
+	}
+
+	//A hook may have changed the book, so check it again before it is stored
+	if errs := validate.Book(in, true); len(errs) > 0 {
+		logCall(ctx, bookstorev1.BookService_Create_FullMethodName, "book invalid after before create hooks: %v", errs)
+		return nil, invalid(errs)
+	}
+
+	bk, err := s.books.Create(ctx, in)
+	if err == store.ErrBookExists {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	} else if err != nil {
+		return nil, serverError(ctx, bookstorev1.BookService_Create_FullMethodName, err)
+	}
+
+	//The book is stored whatever the after hooks do, so their failures are only logged
+	if err := s.hooks.AfterBookCreate(ctx, bk); err != nil {
+		logCall(ctx, bookstorev1.BookService_Create_FullMethodName, "after create hooks: %v", err)
+	}
+	return toProto(bk), nil
+}
+
+//Replace a book's title, author and price, running the price changed hooks like PUT /v1/books/{ref}
+func (s *Server) Update(ctx context.Context, req *bookstorev1.UpdateRequest) (*bookstorev1.Book, error) {
+	if req.GetRef() == "" {
+		return nil, status.Error(codes.InvalidArgument, "ref is required")
+	}
+	in := &models.Book{Title: req.GetTitle(), Author: req.GetAuthor(), Price: req.GetPrice()}
+	if errs := validate.Book(in, false); len(errs) > 0 {
+		return nil, invalid(errs)
+	}
+
+	before, bk, err := s.books.Update(ctx, req.GetRef(), in)
+	if err == store.ErrNotFound {
+		return nil, status.Error(codes.NotFound, "no such book")
+	} else if err != nil {
+		return nil, serverError(ctx, bookstorev1.BookService_Update_FullMethodName, err)
+	}
+
+	if err := s.hooks.PriceChanged(ctx, before, bk); err != nil {
+		logCall(ctx, bookstorev1.BookService_Update_FullMethodName, "%v", err)
+	}
+	return toProto(bk), nil
+}
+
+//Delete a book by ISBN or UUID
+func (s *Server) Delete(ctx context.Context, req *bookstorev1.DeleteRequest) (*bookstorev1.DeleteResponse, error) {
+	if req.GetRef() == "" {
+		return nil, status.Error(codes.InvalidArgument, "ref is required")
+	}
+
+	rowsAffected, err := s.books.Delete(ctx, req.GetRef())
+	if err == store.ErrNotFound {
+		return nil, status.Error(codes.NotFound, "no such book")
+	} else if err != nil {
+		return nil, serverError(ctx, bookstorev1.BookService_Delete_FullMethodName, err)
+	}
+	return &bookstorev1.DeleteResponse{RowsAffected: rowsAffected}, nil
+}
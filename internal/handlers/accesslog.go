@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/osmumos/bookstore/internal/config"
+)
+
+//Captures the status code and body size a handler wrote, which http.ResponseWriter doesn't expose
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (lw *loggingResponseWriter) WriteHeader(status int) {
+	lw.status = status
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
+	if lw.status == 0 {
+		lw.status = 200
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.bytes += n
+	return n, err
+}
+
+//A log file that can be reopened in place
+//logrotate moves the file and sends SIGHUP; reopening makes us write to the fresh file at the original path
+type logFile struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+func openLogFile(path string) (*logFile, error) {
+	l := &logFile{path: path}
+	return l, l.reopen()
+}
+
+func (l *logFile) reopen() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	old := l.f
+	l.f = f
+	l.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Write(p)
+}
+
+//Wrap a handler with an Apache-style access log
+//format is config.AccessLogCommon or config.AccessLogCombined (empty disables the log); a non-empty path writes to that file instead of stdout
+//common:   host ident authuser [date] "request" status bytes
+//combined: common plus "referer" "user-agent"
+func AccessLog(next http.Handler, format, path string) http.Handler {
+	if format == "" {
+		return next
+	}
+
+	var out io.Writer = os.Stdout
+	if path != "" {
+		lf, err := openLogFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reopenOnHangup(lf)
+		out = lf
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r)
+
+		//A handler that writes nothing gets an implicit 200, as in instrument
+		if lw.status == 0 {
+			lw.status = 200
+		}
+
+		line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+			orDash(remoteHost(r)),
+			orDash(username(r)),
+			start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method, r.RequestURI, r.Proto,
+			lw.status,
+			orDash(byteCount(lw.bytes)),
+		)
+		if format == config.AccessLogCombined {
+			line += fmt.Sprintf(" %q %q", orDash(r.Referer()), orDash(r.UserAgent()))
+		}
+		fmt.Fprintln(out, line)
+	})
+}
+
+//Reopen the access log file on SIGHUP, the signal log rotation tools send after moving the file
+func reopenOnHangup(lf *logFile) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := lf.reopen(); err != nil {
+				log.Printf("access log: reopen %s: %v", lf.path, err)
+			}
+		}
+	}()
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func username(r *http.Request) string {
+	user, _, _ := r.BasicAuth()
+	return user
+}
+
+//CLF writes "-" rather than 0 when no body was sent
+func byteCount(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprint(n)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
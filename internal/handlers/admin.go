@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/osmumos/bookstore/internal/store"
+)
+
+//How many captured plans GET /admin/plans returns
+const maxPlans = 100
+
+//How many statements GET /admin/statements returns by default, and at most
+const (
+	defaultStatements = 20
+	maxStatements     = 100
+)
+
+//List the queries whose plans can be captured, with the parameters each one takes
+func (h *Handler) adminQueries(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, store.NamedQueries())
+}
+
+//Capture the EXPLAIN (ANALYZE) plan of a named query, with its arguments given as form fields named after its params
+//e.g. curl -i -X POST -d "query=book_by_isbn&isbn=978-1503261969" localhost:3000/admin/plans
+func (h *Handler) adminPlansCreate(w http.ResponseWriter, r *http.Request) {
+	q, ok := store.LookupQuery(r.FormValue("query"))
+	if !ok {
+		writeError(w, r, 404)
+		return
+	}
+
+	args := make([]string, 0, len(q.Params))
+	for _, p := range q.Params {
+		v := strings.TrimSpace(r.FormValue(p))
+		if v == "" {
+			writeProblem(w, r, 400, p+" is required")
+			return
+		}
+		args = append(args, v)
+	}
+
+	//The store parses the arguments, e.g. that id is a UUID, since it knows what each query needs
+	plan, err := h.store.CapturePlan(r.Context(), q.Name, args)
+	if errors.Is(err, store.ErrBadArgument) {
+		writeProblem(w, r, 400, err.Error())
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, 201, plan)
+}
+
+//The latest captured plans, newest first, e.g. /admin/plans?query=books_search_title
+func (h *Handler) adminPlansIndex(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("query")
+	if _, ok := store.LookupQuery(name); name != "" && !ok {
+		writeError(w, r, 404)
+		return
+	}
+
+	plans, err := h.store.ListPlans(r.Context(), name, maxPlans)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, 200, plans)
+}
+
+//The application's heaviest statements from pg_stat_statements, for triage without a database shell
+//e.g. /admin/statements?order=calls&limit=10. order is total_time (the default), mean_time or calls
+//Answers 501 when pg_stat_statements isn't available in the database
+func (h *Handler) adminStatements(w http.ResponseWriter, r *http.Request) {
+	order := r.FormValue("order")
+	if order == "" {
+		order = "total_time"
+	} else if !store.ValidStatementOrder(order) {
+		writeProblem(w, r, 400, "order must be total_time, mean_time or calls")
+		return
+	}
+
+	limit := defaultStatements
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeProblem(w, r, 400, "limit must be a positive integer")
+			return
+		}
+		if n > maxStatements {
+			n = maxStatements
+		}
+		limit = n
+	}
+
+	stats, err := h.store.TopStatements(r.Context(), order, limit)
+	if err == store.ErrStatementsUnavailable {
+		logRequest(r, "%v", err)
+		writeProblem(w, r, 501, "pg_stat_statements is not available in the database")
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, 200, stats)
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/osmumos/bookstore/internal/store"
+	"github.com/osmumos/bookstore/internal/validate"
+)
+
+//Issue an API key for a machine client
+//e.g. curl -i -X POST -H "Authorization: Bearer <token>" -d "name=stock sync&role=clerk" localhost:3000/v1/api-keys
+//The key is in the response and nowhere else: store it, it can't be shown again
+func (h *Handler) apiKeysCreate(w http.ResponseWriter, r *http.Request) {
+	name, role := r.FormValue("name"), r.FormValue("role")
+	if name == "" || role == "" {
+		writeProblem(w, r, 400, "name and role are required")
+		return
+	}
+	if utf8.RuneCountInString(name) > validate.MaxTextLen {
+		var errs validate.Errors
+		errs.Add("name", "must be at most 255 characters")
+		writeInvalid(w, r, errs)
+		return
+	}
+
+	k, err := h.store.CreateAPIKey(r.Context(), name, role)
+	if err == store.ErrUnknownRole {
+		writeProblem(w, r, 400, "unknown role "+role)
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, 201, k)
+}
+
+//List every key with its role and when it was last used. The secrets themselves are never shown
+func (h *Handler) apiKeysIndex(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.store.ListAPIKeys(r.Context())
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, 200, keys)
+}
+
+//Revoke a key. Requests using it are refused from then on
+//e.g. curl -i -X DELETE -H "Authorization: Bearer <token>" localhost:3000/v1/api-keys/<id>
+func (h *Handler) apiKeysRevoke(w http.ResponseWriter, r *http.Request) {
+	k, err := h.store.RevokeAPIKey(r.Context(), r.PathValue("id"))
+	if err == store.ErrNotFound {
+		writeError(w, r, 404)
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, 200, k)
+}
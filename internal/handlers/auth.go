@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/osmumos/bookstore/internal/models"
+	"github.com/osmumos/bookstore/internal/store"
+)
+
+//Issuer of our tokens, checked on the way back in
+const tokenIssuer = "bookstore"
+
+type userKey struct{}
+
+//The authenticated user of a request, set by requireAuth
+//For an API key, ID is the key's id and Username its name prefixed with "key:"
+type authUser struct {
+	ID       string
+	Username string
+	Role     string
+}
+
+//Roles allowed to change the catalog, and to use the /admin tools
+var (
+	catalogWriters = []string{models.RoleAdmin, models.RoleClerk}
+	admins         = []string{models.RoleAdmin}
+)
+
+//Claims carried by our tokens. The subject is the user's UUID
+//The role is fixed when the token is issued, so a role change takes effect at the user's next login
+type tokenClaims struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+//Exchange a username and password for a signed token
+//e.g. curl -i -X POST -d "username=alice&password=secret" localhost:3000/v1/login
+//Send the token back as "Authorization: Bearer <token>" on the write endpoints
+func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
+	username, password := r.FormValue("username"), r.FormValue("password")
+	if username == "" || password == "" {
+		writeProblem(w, r, 400, "username and password are required")
+		return
+	}
+
+	u, err := h.store.Authenticate(r.Context(), username, password)
+	if err == store.ErrInvalidCredentials {
+		writeError(w, r, 401)
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	expires := now.Add(h.tokenLifetime)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims{
+		Username: u.Username,
+		Role:     u.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   u.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	}).SignedString(h.signingKey)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, 200, map[string]interface{}{"token": token, "expires_at": expires.UTC().Truncate(time.Second)})
+}
+
+//Only let requests with a valid bearer token or API key through
+//The token must be signed with our key using HS256 and not be expired. An API key, sent as X-API-Key,
+//must exist and not be revoked. Anything else gets a 401
+func (h *Handler) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			k, err := h.store.AuthenticateAPIKey(r.Context(), key)
+			if err == store.ErrInvalidCredentials {
+				writeProblem(w, r, 401, "invalid or revoked API key")
+				return
+			} else if err != nil {
+				serverError(w, r, err)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userKey{}, &authUser{ID: k.ID, Username: "key:" + k.Name, Role: k.Role})
+			next(w, r.WithContext(ctx))
+			return
+		}
+
+		raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || raw == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bookstore"`)
+			writeError(w, r, 401)
+			return
+		}
+
+		//Pinning the method stops a token from choosing its own algorithm, e.g. "none"
+		claims := new(tokenClaims)
+		_, err := jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (interface{}, error) { return h.signingKey, nil },
+			jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(tokenIssuer), jwt.WithExpirationRequired())
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bookstore", error="invalid_token"`)
+			writeProblem(w, r, 401, "invalid or expired token")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userKey{}, &authUser{ID: claims.Subject, Username: claims.Username, Role: claims.Role})
+		next(w, r.WithContext(ctx))
+	}
+}
+
+//Only let requests with a valid bearer token for one of roles through
+//A missing or bad token gets a 401 as in requireAuth; a valid token with another role gets a 403
+//Wrap it in withBudget rather than the other way round: an API key is looked up in the database, which needs the deadline too
+func (h *Handler) requireRole(roles []string, next http.HandlerFunc) http.HandlerFunc {
+	return h.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		u := r.Context().Value(userKey{}).(*authUser)
+		for _, role := range roles {
+			if u.Role == role {
+				next(w, r)
+				return
+			}
+		}
+		writeError(w, r, 403)
+	})
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/osmumos/bookstore/internal/hooks"
+	"github.com/osmumos/bookstore/internal/models"
+	"github.com/osmumos/bookstore/internal/store"
+	"github.com/osmumos/bookstore/internal/validate"
+)
+
+//Read the page and per_page querystring parameters
+//Both are optional, with the defaults and limits of store.Paging. Anything given must be a positive number
+func readPage(r *http.Request) (page, perPage int, err error) {
+	for _, pf := range []struct {
+		param string
+		dest  *int
+	}{
+		{"page", &page},
+		{"per_page", &perPage},
+	} {
+		v := r.FormValue(pf.param)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, store.ErrBadPage
+		}
+		*pf.dest = n
+	}
+
+	return store.Paging(page, perPage)
+}
+
+//List books a page at a time, e.g. /v1/books?page=2&per_page=50
+//Optionally filtered on the timestamps, e.g. /v1/books?updated_after=2024-01-01T00:00:00Z
+func (h *Handler) booksIndex(w http.ResponseWriter, r *http.Request) {
+	var f models.BookFilter
+	for _, tf := range []struct {
+		param string
+		dest  *time.Time
+	}{
+		{"created_after", &f.CreatedAfter},
+		{"created_before", &f.CreatedBefore},
+		{"updated_after", &f.UpdatedAfter},
+		{"updated_before", &f.UpdatedBefore},
+	} {
+		v := r.FormValue(tf.param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeProblem(w, r, 400, tf.param+" must be an RFC 3339 time")
+			return
+		}
+		*tf.dest = t
+	}
+
+	h.writeBookPage(w, r, f)
+}
+
+//Search books by partial title or author and by price range
+//e.g. /v1/books/search?title=time&author=wells&min_price=5&max_price=10
+//Every parameter is optional; given ones are combined with AND. Results are paged like /books
+func (h *Handler) booksSearch(w http.ResponseWriter, r *http.Request) {
+	f := models.BookFilter{Title: r.FormValue("title"), Author: r.FormValue("author")}
+
+	for _, pf := range []struct {
+		param string
+		dest  **float64
+	}{
+		{"min_price", &f.MinPrice},
+		{"max_price", &f.MaxPrice},
+	} {
+		v := r.FormValue(pf.param)
+		if v == "" {
+			continue
+		}
+		//ParseFloat accepts NaN and Inf, which compare false with everything and so would slip past p < 0
+		p, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(p) || math.IsInf(p, 0) || p < 0 {
+			writeProblem(w, r, 400, pf.param+" must be a non-negative number")
+			return
+		}
+		*pf.dest = &p
+	}
+
+	h.writeBookPage(w, r, f)
+}
+
+//Answer with one page of the books matching f. The page comes from the page/per_page parameters
+func (h *Handler) writeBookPage(w http.ResponseWriter, r *http.Request, f models.BookFilter) {
+	page, perPage, err := readPage(r)
+	if err != nil {
+		writeProblem(w, r, 400, err.Error())
+		return
+	}
+
+	//A bad row fails this request only, never the whole server
+	bp, err := h.books.List(r.Context(), f, page, perPage)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, 200, bp)
+}
+
+//Querying a single row
+//e.g. /v1/books/978-1503261969 or /v1/books/<uuid>
+//Add ?as_of=<RFC 3339 time> to get the book as it was at that moment
+func (h *Handler) booksShow(w http.ResponseWriter, r *http.Request) {
+	var asOf time.Time
+	if v := r.FormValue("as_of"); v != "" {
+		var err error
+		if asOf, err = time.Parse(time.RFC3339, v); err != nil {
+			writeProblem(w, r, 400, "as_of must be an RFC 3339 time")
+			return
+		}
+	}
+
+	bk, err := h.books.Get(r.Context(), r.PathValue("ref"), asOf)
+	if err == store.ErrNotFound {
+		writeError(w, r, 404)
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, 200, bk)
+}
+
+//Create a New Book
+//e.g. curl -i -X POST -d "isbn=978-1470184841&title=Metamorphosis&author=Franz Kafka&price=5.90" localhost:3000/v1/books
+func (h *Handler) booksCreate(w http.ResponseWriter, r *http.Request) {
+	in, errs := readBookForm(r)
+	if errs = append(validate.Book(in, true), errs...); len(errs) > 0 {
+		writeInvalid(w, r, errs)
+		return
+	}
+
+	//Plugins may adjust the book or refuse it, see internal/hooks
+	if err := h.hooks.BeforeBookCreate(r.Context(), in); errors.Is(err, hooks.ErrRejected) {
+		logRequest(r, "%v", err)
+		writeProblem(w, r, 422, err.Error())
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	//A hook may have changed the book, so check it again before it is stored
+	if errs := validate.Book(in, true); len(errs) > 0 {
+		logRequest(r, "book invalid after before create hooks: %v", errs)
+		writeInvalid(w, r, errs)
+		return
+	}
+
+	bk, err := h.books.Create(r.Context(), in)
+	if err == store.ErrBookExists {
+		writeProblem(w, r, 409, err.Error())
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	//The book is stored whatever the after hooks do, so their failures are only logged
+	if err := h.hooks.AfterBookCreate(r.Context(), bk); err != nil {
+		logRequest(r, "after create hooks: %v", err)
+	}
+
+	//Answer with the stored record, including the id and timestamps the database assigned
+	writeJSON(w, 201, bk)
+}
+
+//Update an existing Book's title, author and price
+//e.g. curl -i -X PUT -d "title=Metamorphosis&author=Franz Kafka&price=6.50" localhost:3000/v1/books/978-1470184841
+func (h *Handler) booksUpdate(w http.ResponseWriter, r *http.Request) {
+	in, errs := readBookForm(r)
+	if errs = append(validate.Book(in, false), errs...); len(errs) > 0 {
+		writeInvalid(w, r, errs)
+		return
+	}
+
+	before, bk, err := h.books.Update(r.Context(), r.PathValue("ref"), in)
+	if err == store.ErrNotFound {
+		writeError(w, r, 404)
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	if err := h.hooks.PriceChanged(r.Context(), before, bk); err != nil {
+		logRequest(r, "%v", err)
+	}
+
+	writeJSON(w, 200, bk)
+}
+
+//Delete a Book
+//e.g. curl -i -X DELETE localhost:3000/v1/books/978-1470184841
+func (h *Handler) booksDelete(w http.ResponseWriter, r *http.Request) {
+	rowsAffected, err := h.books.Delete(r.Context(), r.PathValue("ref"))
+	if err == store.ErrNotFound {
+		writeError(w, r, 404)
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, 200, map[string]int64{"rows_affected": rowsAffected})
+}
+
+//Get the Form Parameters shared by create and update
+//r.FormValue() reads the request body for POST and PUT alike. Only a price that doesn't parse is reported here;
+//everything else is checked by validate.Book. The ISBN is only needed to create a book (update takes it from the path)
+func readBookForm(r *http.Request) (*models.Book, validate.Errors) {
+	bk := &models.Book{
+		ISBN:   r.FormValue("isbn"),
+		Title:  r.FormValue("title"),
+		Author: r.FormValue("author"),
+	}
+
+	//Parse string for price
+	var errs validate.Errors
+	if v := r.FormValue("price"); v == "" {
+		errs.Add("price", "is required")
+	} else if price, err := strconv.ParseFloat(v, 32); err != nil {
+		errs.Add("price", "must be a number")
+	} else {
+		bk.Price = float32(price)
+	}
+
+	return bk, errs
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+//Every route of the versioned API and every operational endpoint is in openapi.json, and everything in openapi.json is a route
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handler{metrics: newMetrics()}
+	mounted := make(map[string]bool)
+	for _, rt := range h.v1() {
+		mounted[rt.method+" /v1"+rt.path] = true
+	}
+	for _, rt := range h.operational() {
+		//The spec doesn't describe itself
+		if rt.path != "/openapi.json" {
+			mounted[rt.method+" "+rt.path] = true
+		}
+	}
+
+	for r := range mounted {
+		method, path, _ := strings.Cut(r, " ")
+		if _, ok := spec.Paths[path][strings.ToLower(method)]; !ok {
+			t.Errorf("%s is routed but not in openapi.json", r)
+		}
+	}
+
+	for path, item := range spec.Paths {
+		for method := range item {
+			switch method {
+			case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+			default:
+				//Path-level fields such as parameters
+				continue
+			}
+			if r := strings.ToUpper(method) + " " + path; !mounted[r] {
+				t.Errorf("%s is in openapi.json but not routed", r)
+			}
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/osmumos/bookstore/internal/requestid"
+)
+
+//Handle an unexpected error from the database (or any other dependency) inside a request handler
+//The error is logged with the request it happened in and the client gets a 500. Handlers must never log.Fatal:
+//that would take every other in-flight request down with it.
+//If the request budget ran out the client gets a 503 instead, since retrying later may well succeed
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		logRequest(r, "request budget exceeded: %v", err)
+		writeProblem(w, r, 503, "request budget exceeded")
+		return
+	}
+
+	logRequest(r, "%v", err)
+	writeError(w, r, 500)
+}
+
+//Log a message about r, prefixed with its method, path and request ID so it can be matched with the client's report
+func logRequest(r *http.Request, format string, args ...interface{}) {
+	prefix := []interface{}{r.Method, r.URL.Path, requestid.From(r.Context())}
+	log.Printf("%s %s request_id=%s: "+format, append(prefix, args...)...)
+}
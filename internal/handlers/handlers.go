@@ -0,0 +1,210 @@
+//Package handlers serves the HTTP API on top of the store
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/osmumos/bookstore/internal/config"
+	"github.com/osmumos/bookstore/internal/hooks"
+	"github.com/osmumos/bookstore/internal/store"
+)
+
+//Handler holds what the HTTP handlers need, so nothing lives in package globals
+type Handler struct {
+	store *store.Store
+	books store.BookStore
+
+	//Plugin hooks run around book changes
+	hooks *hooks.Registry
+
+	//Deadline for each request, see withBudget
+	requestTimeout time.Duration
+
+	//Serve the /admin routes, and the Swagger UI page at /docs
+	adminEnabled bool
+	swaggerUI    bool
+
+	metrics *metrics
+	cors    *corsPolicy
+
+	//HMAC key for signing and checking login tokens, and how long a token is valid
+	signingKey    []byte
+	tokenLifetime time.Duration
+
+	//Dependencies checked by /readyz. Each check must return quickly and respect the context deadline
+	readinessChecks []readinessCheck
+}
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+//Create the handlers for s, configured by cfg
+//Book routes go through books, so they can be served from something other than Postgres
+func New(s *store.Store, books store.BookStore, cfg *config.Config) *Handler {
+	return &Handler{
+		store:          s,
+		books:          books,
+		hooks:          hooks.Default,
+		requestTimeout: cfg.RequestTimeout,
+		adminEnabled:   cfg.AdminEnabled,
+		swaggerUI:      cfg.SwaggerUI,
+		metrics:        newMetrics(s.Collector()),
+		cors:           newCORSPolicy(cfg.CORSAllowedOrigins, cfg.CORSAllowedMethods, cfg.CORSAllowedHeaders, cfg.CORSMaxAge),
+		signingKey:     []byte(cfg.JWTSigningKey),
+		tokenLifetime:  cfg.TokenLifetime,
+		readinessChecks: []readinessCheck{
+			{"database", s.Ping},
+		},
+	}
+}
+
+//One API route, relative to its version prefix
+type route struct {
+	method, path string
+	handler      http.HandlerFunc
+}
+
+//Version 1 of the API
+func (h *Handler) v1() []route {
+	return []route{
+		{"GET", "/books", secure(apiRoute, h.withBudget(h.booksIndex))},
+		{"POST", "/books", secure(apiRoute, h.withBudget(h.requireRole(catalogWriters, h.booksCreate)))},
+		{"GET", "/books/search", secure(apiRoute, h.withBudget(h.booksSearch))},
+		{"GET", "/books/{ref}", secure(apiRoute, h.withBudget(h.booksShow))},
+		{"PUT", "/books/{ref}", secure(apiRoute, h.withBudget(h.requireRole(catalogWriters, h.booksUpdate)))},
+		{"DELETE", "/books/{ref}", secure(apiRoute, h.withBudget(h.requireRole(catalogWriters, h.booksDelete)))},
+		{"GET", "/snapshots", secure(apiRoute, h.withBudget(h.snapshotsIndex))},
+		{"POST", "/snapshots", secure(apiRoute, h.withBudget(h.requireRole(catalogWriters, h.snapshotsCreate)))},
+		{"GET", "/snapshots/diff", secure(apiRoute, h.withBudget(h.snapshotsDiff))},
+		{"POST", "/login", secure(apiRoute, h.withBudget(h.login))},
+		{"GET", "/api-keys", secure(apiRoute, h.withBudget(h.requireRole(admins, h.apiKeysIndex)))},
+		{"POST", "/api-keys", secure(apiRoute, h.withBudget(h.requireRole(admins, h.apiKeysCreate)))},
+		{"DELETE", "/api-keys/{id}", secure(apiRoute, h.withBudget(h.requireRole(admins, h.apiKeysRevoke)))},
+	}
+}
+
+//Operational endpoints, which aren't part of the versioned API
+func (h *Handler) operational() []route {
+	return []route{
+		{"GET", "/readyz", secure(apiRoute, h.readyz)},
+		{"GET", "/selftest", secure(apiRoute, h.withBudget(h.selftest))},
+		{"GET", "/metrics", secure(apiRoute, h.metrics.handler())},
+		{"GET", "/openapi.json", secure(apiRoute, h.openAPI)},
+	}
+}
+
+//The endpoints of the first API, which named the book in the querystring or form (/books/show?isbn=...),
+//and the v1 routes that replaced them. They are still served, by those routes' handlers, so scripts written against them keep working.
+//Like the unversioned paths they are deprecated, with a Link to the replacement for the same book
+var compatRoutes = []struct{ method, path, successor string }{
+	{"GET", "/books/show", "/books/{ref}"},
+	{"POST", "/books/create", "/books"},
+	{"PUT", "/books/update", "/books/{ref}"},
+	{"DELETE", "/books/delete", "/books/{ref}"},
+	{"POST", "/snapshots/create", "/snapshots"},
+}
+
+//The compatibility routes, each served by the v1 handler of its successor
+func (h *Handler) compat() []route {
+	v1 := make(map[string]http.HandlerFunc)
+	for _, rt := range h.v1() {
+		v1[rt.method+" "+rt.path] = rt.handler
+	}
+
+	var routes []route
+	for _, c := range compatRoutes {
+		next := v1[c.method+" "+c.successor]
+		successor := legacyVersion + c.successor
+		if !strings.Contains(c.successor, "{ref}") {
+			routes = append(routes, route{c.method, c.path, deprecatedFor(func(*http.Request) string { return successor }, next)})
+			continue
+		}
+
+		//Name the book in the Link the same way the client named it
+		link := func(r *http.Request) string {
+			ref := formRef(r)
+			if ref == "" {
+				return ""
+			}
+			return strings.Replace(successor, "{ref}", url.PathEscape(ref), 1)
+		}
+		routes = append(routes, route{c.method, c.path, deprecatedFor(link, secure(apiRoute, bookFromForm(next)))})
+	}
+	return routes
+}
+
+//The book a compatibility route names, by its id or isbn parameter
+//update sends it in the form body, show and delete in the querystring; r.FormValue reads both
+func formRef(r *http.Request) string {
+	if id := r.FormValue("id"); id != "" {
+		return id
+	}
+	return r.FormValue("isbn")
+}
+
+//Serve a /books/{ref} handler on a compatibility route, which names the book with a parameter instead
+func bookFromForm(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ref := formRef(r)
+		if ref == "" {
+			writeProblem(w, r, 400, "isbn or id is required")
+			return
+		}
+
+		r.SetPathValue("ref", ref)
+		next(w, r)
+	}
+}
+
+//The unversioned paths (/books, ...) predate /v1 and still serve v1 so existing scripts keep working
+//Their responses are marked deprecated and point at the versioned path
+const legacyVersion = "/v1"
+
+//Register routes on mux under prefix, e.g. "GET /v1/books"
+func mount(mux *http.ServeMux, prefix string, routes []route) {
+	for _, rt := range routes {
+		mux.HandleFunc(rt.method+" "+prefix+rt.path, rt.handler)
+	}
+}
+
+//Map routes to handlers
+//Patterns are "METHOD /path" with {name} path parameters (Go 1.22 ServeMux). A request for a known path
+//with the wrong method gets a 405 with an Allow header from the mux itself, so handlers don't check r.Method
+//API versions are served side by side: a v2 gets its own route list and prefix, and v1 carries on unchanged
+func (h *Handler) Routes() http.Handler {
+	mux := http.NewServeMux()
+
+	mount(mux, "/v1", h.v1())
+
+	legacy := h.v1()
+	for i := range legacy {
+		legacy[i].handler = deprecated(legacyVersion, legacy[i].handler)
+	}
+	mount(mux, "", legacy)
+	mount(mux, "", h.compat())
+
+	mount(mux, "", h.operational())
+
+	if h.swaggerUI {
+		mux.HandleFunc("GET /docs", secure(docsRoute, h.docs))
+		mux.HandleFunc("GET /docs/init.js", secure(docsRoute, h.docsInit))
+		mux.HandleFunc("GET /docs/swagger-ui.css", secure(docsRoute, h.docsAsset))
+		mux.HandleFunc("GET /docs/swagger-ui-bundle.js", secure(docsRoute, h.docsAsset))
+	}
+
+	//Development and tuning tools, for admins only. Off by default: capturing a plan runs the query, and the plans reveal the schema
+	if h.adminEnabled {
+		mux.HandleFunc("GET /admin/queries", secure(apiRoute, h.withBudget(h.requireRole(admins, h.adminQueries))))
+		mux.HandleFunc("GET /admin/plans", secure(apiRoute, h.withBudget(h.requireRole(admins, h.adminPlansIndex))))
+		mux.HandleFunc("POST /admin/plans", secure(apiRoute, h.withBudget(h.requireRole(admins, h.adminPlansCreate))))
+		mux.HandleFunc("GET /admin/statements", secure(apiRoute, h.withBudget(h.requireRole(admins, h.adminStatements))))
+	}
+
+	return withRequestID(h.metrics.instrument(mux, recoverPanics(h.cors.handler(tagRoutes(mux, jsonMuxErrors(mux))))))
+}
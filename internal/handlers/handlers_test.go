@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/osmumos/bookstore/internal/hooks"
+	"github.com/osmumos/bookstore/internal/models"
+	"github.com/osmumos/bookstore/internal/store"
+	"github.com/osmumos/bookstore/internal/validate"
+)
+
+const testBookID = "6f1d4c1e-3b8a-4c3e-9a57-0b3c2f1e5d77"
+
+//BookStore holding one book, enough to drive the book routes without Postgres
+type stubBooks struct {
+	book      models.Book
+	createErr error
+}
+
+func (s *stubBooks) match(ref string) bool {
+	if ref == s.book.ID {
+		return true
+	}
+	isbn, ok := validate.ISBN(ref)
+	return ok && isbn == s.book.ISBN
+}
+
+func (s *stubBooks) List(ctx context.Context, f models.BookFilter, page, perPage int) (*models.BookPage, error) {
+	return &models.BookPage{Books: []*models.Book{&s.book}, Page: page, PerPage: perPage, Total: 1}, nil
+}
+
+func (s *stubBooks) Get(ctx context.Context, ref string, asOf time.Time) (*models.Book, error) {
+	if !s.match(ref) {
+		return nil, store.ErrNotFound
+	}
+	bk := s.book
+	return &bk, nil
+}
+
+func (s *stubBooks) Create(ctx context.Context, in *models.Book) (*models.Book, error) {
+	if s.createErr != nil {
+		return nil, s.createErr
+	}
+	bk := *in
+	bk.ID = testBookID
+	return &bk, nil
+}
+
+func (s *stubBooks) Update(ctx context.Context, ref string, in *models.Book) (before, after *models.Book, err error) {
+	if !s.match(ref) {
+		return nil, nil, store.ErrNotFound
+	}
+	b, a := s.book, s.book
+	a.Title, a.Author, a.Price = in.Title, in.Author, in.Price
+	return &b, &a, nil
+}
+
+func (s *stubBooks) Delete(ctx context.Context, ref string) (int64, error) {
+	if !s.match(ref) {
+		return 0, store.ErrNotFound
+	}
+	return 1, nil
+}
+
+//A handler on books, and a clerk token for its write routes
+func newTestHandler(t *testing.T, books store.BookStore) (*Handler, string) {
+	t.Helper()
+	h := &Handler{
+		books:          books,
+		hooks:          new(hooks.Registry),
+		requestTimeout: time.Second,
+		metrics:        newMetrics(),
+		cors:           newCORSPolicy(nil, nil, nil, 0),
+		signingKey:     []byte("test signing key"),
+	}
+
+	return h, testToken(t, h, models.RoleClerk)
+}
+
+//A valid token from h for a user with role
+func testToken(t *testing.T, h *Handler, role string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims{
+		Username: role,
+		Role:     role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   "0c8e2f4a-5b6d-4e7f-8a9b-1c2d3e4f5a6b",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString(h.signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+//Send a request to the full route table, with form fields in the body
+func serve(h http.Handler, method, target, token string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	if form != nil {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+//The calls scripts made against the first API still work, and point at their /v1 replacement
+func TestCompatRoutes(t *testing.T) {
+	books := &stubBooks{book: models.Book{ID: testBookID, ISBN: "978-1503261969", Title: "Emma", Author: "Jane Austen", Price: 9.44}}
+	h, token := newTestHandler(t, books)
+	routes := h.Routes()
+
+	book := url.Values{"isbn": {"978-1503261969"}, "title": {"Emma"}, "author": {"Jane Austen"}, "price": {"9.44"}}
+	for _, tc := range []struct {
+		method, target string
+		token          string
+		form           url.Values
+		status         int
+		link           string
+	}{
+		{"GET", "/books", "", nil, 200, "</v1/books>"},
+		{"GET", "/books/show?isbn=978-1503261969", "", nil, 200, "</v1/books/978-1503261969>"},
+		{"GET", "/books/show?isbn=9781503261969", "", nil, 200, "</v1/books/9781503261969>"},
+		{"GET", "/books/show?id=" + testBookID, "", nil, 200, "</v1/books/" + testBookID + ">"},
+		{"GET", "/books/show?isbn=978-0306406157", "", nil, 404, "</v1/books/978-0306406157>"},
+		{"GET", "/books/show", "", nil, 400, ""},
+		{"POST", "/books/create", token, book, 201, "</v1/books>"},
+		{"POST", "/books/create", "", book, 401, "</v1/books>"},
+		{"PUT", "/books/update", token, book, 200, "</v1/books/978-1503261969>"},
+		{"PUT", "/books/update", "", book, 401, "</v1/books/978-1503261969>"},
+		{"DELETE", "/books/delete?isbn=978-1503261969", token, nil, 200, "</v1/books/978-1503261969>"},
+		{"DELETE", "/books/delete", token, nil, 400, ""},
+		{"POST", "/snapshots/create", "", url.Values{"label": {"before"}}, 401, "</v1/snapshots>"},
+	} {
+		w := serve(routes, tc.method, tc.target, tc.token, tc.form)
+		if w.Code != tc.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tc.method, tc.target, w.Code, tc.status, w.Body)
+		}
+		if got := w.Header().Get("Link"); tc.link != "" && got != tc.link+`; rel="successor-version"` {
+			t.Errorf("%s %s: Link %q, want %s", tc.method, tc.target, got, tc.link)
+		}
+		if tc.link != "" && w.Header().Get("Deprecation") != "true" {
+			t.Errorf("%s %s: not marked deprecated", tc.method, tc.target)
+		}
+	}
+
+	//The same book comes back as from /v1
+	var bk models.Book
+	w := serve(routes, "GET", "/books/show?isbn=978-1503261969", "", nil)
+	if err := json.NewDecoder(w.Body).Decode(&bk); err != nil || bk.ID != testBookID {
+		t.Errorf("GET /books/show: got %+v, %v", bk, err)
+	}
+	if w := serve(routes, "GET", "/v1/books/978-1503261969", "", nil); w.Header().Get("Deprecation") != "" {
+		t.Errorf("GET /v1/books/{ref} is marked deprecated")
+	}
+}
+
+//A book changed by a hook is validated again, and a duplicate ISBN is a conflict rather than a server error
+func TestBooksCreateChecks(t *testing.T) {
+	form := url.Values{"isbn": {"978-1503261969"}, "title": {"Emma"}, "author": {"Jane Austen"}, "price": {"9.44"}}
+
+	books := &stubBooks{}
+	h, token := newTestHandler(t, books)
+	h.hooks.OnBeforeBookCreate(func(ctx context.Context, bk *models.Book) error {
+		bk.Price = -1
+		return nil
+	})
+	w := serve(h.Routes(), "POST", "/v1/books", token, form)
+	var p problem
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil || w.Code != 400 || len(p.InvalidParams) != 1 || p.InvalidParams[0].Name != "price" {
+		t.Errorf("book made invalid by a hook: status %d, %+v, %v", w.Code, p, err)
+	}
+
+	books = &stubBooks{createErr: store.ErrBookExists}
+	h, token = newTestHandler(t, books)
+	if w := serve(h.Routes(), "POST", "/v1/books", token, form); w.Code != 409 {
+		t.Errorf("duplicate ISBN: status %d, want 409: %s", w.Code, w.Body)
+	}
+}
+
+//A failed readiness check says so without passing on the database's error
+func TestReadyzHidesErrors(t *testing.T) {
+	h, _ := newTestHandler(t, &stubBooks{})
+	h.readinessChecks = []readinessCheck{
+		{"database", func(ctx context.Context) error {
+			return errors.New(`dial tcp db.internal:5432: password authentication failed for user "bookstore"`)
+		}},
+	}
+
+	w := serve(h.Routes(), "GET", "/readyz", "", nil)
+	if w.Code != 503 {
+		t.Errorf("status %d, want 503", w.Code)
+	}
+	if body := w.Body.String(); strings.Contains(body, "db.internal") || strings.Contains(body, "bookstore") {
+		t.Errorf("error leaked: %s", body)
+	}
+}
+
+//An API key name the api_keys table can't hold is refused before it reaches the database
+func TestAPIKeyNameLength(t *testing.T) {
+	h, _ := newTestHandler(t, &stubBooks{})
+	token := testToken(t, h, models.RoleAdmin)
+
+	w := serve(h.Routes(), "POST", "/v1/api-keys", token, url.Values{"name": {strings.Repeat("é", 256)}, "role": {"clerk"}})
+	var p problem
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil || w.Code != 400 || len(p.InvalidParams) != 1 || p.InvalidParams[0].Name != "name" {
+		t.Errorf("256 character name: status %d, %+v, %v", w.Code, p, err)
+	}
+}
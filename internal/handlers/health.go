@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/osmumos/bookstore/internal/store"
+)
+
+//Shown in place of a failed check's error. Both endpoints are public, and database errors name hosts, users and SQL;
+//the error itself is logged with the request ID, which the client gets in X-Request-ID
+const checkFailed = "unavailable, see the server log"
+
+//Readiness probe for orchestrators
+//Lists every dependency with its status and answers 503 if any of them is down
+func (h *Handler) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	status := 200
+	report := make(map[string]string)
+	for _, c := range h.readinessChecks {
+		report[c.name] = "ok"
+		if err := c.check(ctx); err != nil {
+			logRequest(r, "readiness check %s failed: %v", c.name, err)
+			status = 503
+			report[c.name] = checkFailed
+		}
+	}
+
+	writeJSON(w, status, report)
+}
+
+//End-to-end self check for uptime monitors, see store.Selftest
+func (h *Handler) selftest(w http.ResponseWriter, r *http.Request) {
+	steps, ok := h.store.Selftest(r.Context())
+	if !ok {
+		failed := &steps[len(steps)-1]
+		logRequest(r, "selftest %s failed: %s", failed.Name, failed.Error)
+		failed.Error = checkFailed
+		writeJSON(w, 503, map[string][]store.SelftestStep{"steps": steps})
+		return
+	}
+
+	writeJSON(w, 200, map[string][]store.SelftestStep{"steps": steps})
+}
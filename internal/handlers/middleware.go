@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/osmumos/bookstore/internal/requestid"
+	"github.com/osmumos/bookstore/internal/store"
+)
+
+//Route classes decide which set of security headers a handler gets
+const (
+	apiRoute  = "api"
+	htmlRoute = "html"
+	docsRoute = "docs"
+)
+
+//Headers sent on every route regardless of class
+//HSTS is ignored by browsers over plain HTTP, so it is safe to always send it
+var baseSecurityHeaders = map[string]string{
+	"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+	"X-Content-Type-Options":    "nosniff",
+	"Referrer-Policy":           "strict-origin-when-cross-origin",
+	"X-Frame-Options":           "DENY",
+}
+
+//Content-Security-Policy per route class
+//API responses are never rendered, so nothing is allowed. HTML pages may load their own assets.
+var contentSecurityPolicy = map[string]string{
+	apiRoute:  "default-src 'none'; frame-ancestors 'none'",
+	htmlRoute: "default-src 'self'; img-src 'self' data:; frame-ancestors 'none'",
+	//Swagger UI is served by us too, but sets inline styles on its elements
+	docsRoute: "default-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; frame-ancestors 'none'",
+}
+
+//Wrap a handler so the security headers for its route class are set before it runs
+func secure(class string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		for k, v := range baseSecurityHeaders {
+			h.Set(k, v)
+		}
+		if csp, ok := contentSecurityPolicy[class]; ok {
+			h.Set("Content-Security-Policy", csp)
+		}
+		next(w, r)
+	}
+}
+
+//Give the request a deadline of h.requestTimeout
+//Every query is run with r.Context(), so once the budget is spent (or the client goes away) the query is cancelled
+//and its connection goes back to the pool, instead of each call stacking its own timeout
+func (h *Handler) withBudget(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), h.requestTimeout)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
+
+//Give every request an ID, or keep the one a proxy in front of us sent as X-Request-ID
+//It goes into the context for logs, error responses and SQL comments, and back to the client in the same header
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestid.Use(r.Header.Get(requestid.Header))
+		w.Header().Set(requestid.Header, id)
+		next.ServeHTTP(w, r.WithContext(requestid.With(r.Context(), id)))
+	})
+}
+
+//Turn a panic in a handler into a logged stack trace and a 500, instead of a dropped connection
+//http.ErrAbortHandler is a handler's deliberate way to abort the response, so it is passed on to net/http.
+//If the handler had already started its response nothing more can be sent, and the client sees it cut short
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			logRequest(r, "panic: %v\n%s", p, debug.Stack())
+			if lw.status == 0 {
+				writeError(lw, r, 500)
+			}
+		}()
+		next.ServeHTTP(lw, r)
+	})
+}
+
+//Tag the request context with the route pattern it matched, so the store can label its SQL with it
+//mux.Handler only looks the route up; next still does the actual dispatch
+func tagRoutes(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			r = r.WithContext(store.WithRoute(r.Context(), pattern))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//Mark responses of a deprecated path and name its replacement under successor, e.g. /books -> /v1/books
+//Deprecation is the IETF header for this; clients and proxies can log or alert on it
+func deprecated(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return deprecatedFor(func(r *http.Request) string { return successor + r.URL.EscapedPath() }, next)
+}
+
+//Like deprecated, for a path whose replacement is named some other way, e.g. /books/show?isbn=... -> /v1/books/{isbn}
+//successor gives the replacement's URL for r, or "" when r doesn't say enough to name one
+func deprecatedFor(successor func(r *http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		if u := successor(r); u != "" {
+			w.Header().Set("Link", "<"+u+`>; rel="successor-version"`)
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/osmumos/bookstore/internal/requestid"
+	"github.com/osmumos/bookstore/internal/validate"
+)
+
+//Body of every error response, an RFC 7807 problem, e.g.
+//{"type":"about:blank","title":"Not Found","status":404,"instance":"/v1/books/123","request_id":"..."}
+//The type is about:blank because the status code already says what kind of problem it is.
+//detail, when present, says what was wrong with this particular request
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+
+	//What is wrong with each field of the input, when that is the problem
+	InvalidParams validate.Errors `json:"invalid_params,omitempty"`
+}
+
+//Encode v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	writeBody(w, status, "application/json; charset=utf-8", v)
+}
+
+func writeBody(w http.ResponseWriter, status int, contentType string, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+
+	//The status line is already sent, so an encoding failure can only be logged
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing JSON response: %v", err)
+	}
+}
+
+//ServeMux answers unknown paths (404) and wrong methods (405) itself, in plain text
+//Re-render those as JSON errors like every other response. Headers the mux sets, such as Allow, are kept
+func jsonMuxErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		sw := &statusOnlyWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		writeError(w, r, sw.status)
+	})
+}
+
+//Records the status code a handler sets and throws its body away
+type statusOnlyWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusOnlyWriter) WriteHeader(status int) { sw.status = status }
+
+func (sw *statusOnlyWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = 200
+	}
+	return len(b), nil
+}
+
+//Replacement for http.Error that answers with an application/problem+json body instead of plain text
+func writeError(w http.ResponseWriter, r *http.Request, status int) {
+	writeProblem(w, r, status, "")
+}
+
+//Answer with an RFC 7807 problem for status, explained by detail. Every error response goes through here
+//The request ID lets a client's report be matched with our logs
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	writeBody(w, status, "application/problem+json", newProblem(r, status, detail))
+}
+
+//Answer 400 with the problems of each input field in invalid_params, e.g. [{"name":"price","reason":"must not be negative"}]
+func writeInvalid(w http.ResponseWriter, r *http.Request, errs validate.Errors) {
+	p := newProblem(r, 400, "the input is invalid, see invalid_params")
+	p.InvalidParams = errs
+	writeBody(w, 400, "application/problem+json", p)
+}
+
+func newProblem(r *http.Request, status int, detail string) problem {
+	return problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: requestid.From(r.Context()),
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/osmumos/bookstore/internal/store"
+	"github.com/osmumos/bookstore/internal/validate"
+)
+
+//Snapshot the current catalog
+//e.g. curl -i -X POST -d "label=before spring sale" localhost:3000/v1/snapshots
+func (h *Handler) snapshotsCreate(w http.ResponseWriter, r *http.Request) {
+	label := r.FormValue("label")
+	if utf8.RuneCountInString(label) > validate.MaxTextLen {
+		var errs validate.Errors
+		errs.Add("label", "must be at most 255 characters")
+		writeInvalid(w, r, errs)
+		return
+	}
+
+	snap, err := h.store.CreateSnapshot(r.Context(), label)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, 201, snap)
+}
+
+//List all snapshots, newest first
+func (h *Handler) snapshotsIndex(w http.ResponseWriter, r *http.Request) {
+	snaps, err := h.store.ListSnapshots(r.Context())
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, 200, snaps)
+}
+
+//Compare two snapshots, or a snapshot with the live catalog when to is omitted
+//e.g. /v1/snapshots/diff?from=3&to=7 or /snapshots/diff?from=3
+func (h *Handler) snapshotsDiff(w http.ResponseWriter, r *http.Request) {
+	from, err := strconv.ParseInt(r.FormValue("from"), 10, 64)
+	if err != nil {
+		writeProblem(w, r, 400, "from must be a snapshot id")
+		return
+	}
+
+	var to *int64
+	if v := r.FormValue("to"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeProblem(w, r, 400, "to must be a snapshot id")
+			return
+		}
+		to = &n
+	}
+
+	diff, err := h.store.DiffSnapshots(r.Context(), from, to)
+	if err == store.ErrNotFound {
+		writeError(w, r, 404)
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, 200, diff)
+}
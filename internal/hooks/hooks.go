@@ -0,0 +1,100 @@
+//Package hooks lets deployments run their own code at points in a book's lifecycle without touching the handlers
+//Plugins register from an init function, so importing a plugin package is all it takes to enable it.
+//See plugins/pricelog for an example and cmd/server/plugins_*.go for how build tags select plugins
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/osmumos/bookstore/internal/models"
+)
+
+//Wrap this in the error a before hook returns to refuse the change, e.g. fmt.Errorf("%w: price too low", hooks.ErrRejected)
+//The client gets a 422 (FailedPrecondition over gRPC) with the error text as its detail. Any other error is treated as the hook failing and gets a 500
+var ErrRejected = errors.New("rejected")
+
+//Runs before a book is stored. It may change the book, or return an error to stop it being stored
+type BeforeBookHook func(ctx context.Context, bk *models.Book) error
+
+//Runs after a book was stored. The change is already committed, so an error is only logged
+type AfterBookHook func(ctx context.Context, bk *models.Book) error
+
+//Runs after an update changed a book's price
+type PriceChangedHook func(ctx context.Context, before, after *models.Book) error
+
+//The hooks registered for each lifecycle point, run in registration order
+type Registry struct {
+	mu               sync.RWMutex
+	beforeBookCreate []BeforeBookHook
+	afterBookCreate  []AfterBookHook
+	priceChanged     []PriceChangedHook
+}
+
+//The registry plugins add themselves to and the server runs
+var Default = new(Registry)
+
+//Register fn to run before every book is created
+func (reg *Registry) OnBeforeBookCreate(fn BeforeBookHook) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.beforeBookCreate = append(reg.beforeBookCreate, fn)
+}
+
+//Register fn to run after every book is created
+func (reg *Registry) OnAfterBookCreate(fn AfterBookHook) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.afterBookCreate = append(reg.afterBookCreate, fn)
+}
+
+//Register fn to run whenever an update changes a book's price
+func (reg *Registry) OnPriceChanged(fn PriceChangedHook) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.priceChanged = append(reg.priceChanged, fn)
+}
+
+//Run the before-create hooks, stopping at the first error
+func (reg *Registry) BeforeBookCreate(ctx context.Context, bk *models.Book) error {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	for _, fn := range reg.beforeBookCreate {
+		if err := fn(ctx, bk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Run every after-create hook and return all their errors joined
+func (reg *Registry) AfterBookCreate(ctx context.Context, bk *models.Book) error {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	var errs []error
+	for _, fn := range reg.afterBookCreate {
+		if err := fn(ctx, bk); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+//Run the price-changed hooks if before and after differ in price, and return all their errors joined
+func (reg *Registry) PriceChanged(ctx context.Context, before, after *models.Book) error {
+	if before.Price == after.Price {
+		return nil
+	}
+
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	var errs []error
+	for _, fn := range reg.priceChanged {
+		if err := fn(ctx, before, after); err != nil {
+			errs = append(errs, fmt.Errorf("price changed hook: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
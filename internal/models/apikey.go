@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+//A key for machine-to-machine clients, sent in the X-API-Key header
+//Key is the secret itself. It is only filled in when the key is issued; afterwards only Prefix identifies it
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Key        string     `json:"key,omitempty"`
+	Role       string     `json:"role"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
@@ -0,0 +1,44 @@
+//Package models holds the types shared by the store and the HTTP handlers
+package models
+
+import "time"
+
+//Create the Book type with struct
+//If the DB allowed NULLs then use sql.NullString; sql.NullFloat64 etc
+//Fields are exported so encoding/json can see them; the tags set the names used in responses
+//ID is an immutable UUID assigned by the database. Unlike the ISBN it never changes, so use it for references
+//CreatedAt and UpdatedAt are maintained by the database (column defaults and the books_touch trigger)
+type Book struct {
+	ID        string    `json:"id"`
+	ISBN      string    `json:"isbn"`
+	Title     string    `json:"title"`
+	Author    string    `json:"author"`
+	Price     float32   `json:"price"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+//One page of a listing, with the total number of matching books so clients know how many pages there are
+type BookPage struct {
+	Books   []*Book `json:"books"`
+	Page    int     `json:"page"`
+	PerPage int     `json:"per_page"`
+	Total   int     `json:"total"`
+}
+
+//Which books a listing returns. Zero values don't filter; every set field must match
+type BookFilter struct {
+	//Only books created or last updated strictly after/before these times
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+
+	//Case-insensitive substring matches
+	Title  string
+	Author string
+
+	//Inclusive price bounds
+	MinPrice *float64
+	MaxPrice *float64
+}
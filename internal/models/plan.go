@@ -0,0 +1,25 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+//An EXPLAIN (ANALYZE) plan captured for one of the named queries, see POST /admin/plans
+//Timings are Postgres' own, in milliseconds
+type QueryPlan struct {
+	ID          int64           `json:"id"`
+	Query       string          `json:"query"`
+	Args        []string        `json:"args"`
+	Plan        json.RawMessage `json:"plan"`
+	PlanningMs  float64         `json:"planning_ms"`
+	ExecutionMs float64         `json:"execution_ms"`
+	SeqScans    []*SeqScan      `json:"seq_scans"`
+	CapturedAt  time.Time       `json:"captured_at"`
+}
+
+//A sequential scan in a plan on a table big enough that it probably wants an index
+type SeqScan struct {
+	Table         string  `json:"table"`
+	EstimatedRows float64 `json:"estimated_rows"`
+}
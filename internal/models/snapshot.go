@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+//A saved copy of the whole catalog, taken with POST /snapshots
+type Snapshot struct {
+	ID        int64     `json:"id"`
+	Label     string    `json:"label"`
+	CreatedAt time.Time `json:"created_at"`
+	Books     int       `json:"books"`
+}
+
+//The catalog fields a snapshot records for each book, and the book's UUID to match it with the live catalog
+type SnapshotBook struct {
+	ID     string  `json:"id"`
+	ISBN   string  `json:"isbn"`
+	Title  string  `json:"title"`
+	Author string  `json:"author"`
+	Price  float64 `json:"price"`
+}
+
+//A book present in both catalogs whose fields differ
+type SnapshotChange struct {
+	ID     string        `json:"id"`
+	Before *SnapshotBook `json:"before"`
+	After  *SnapshotBook `json:"after"`
+}
+
+//What changed between two catalogs. Books are matched on their UUID, so an ISBN correction shows up as a change
+//To is nil when the comparison was against the live catalog
+type SnapshotDiff struct {
+	From    int64             `json:"from"`
+	To      *int64            `json:"to"`
+	Added   []*SnapshotBook   `json:"added"`
+	Removed []*SnapshotBook   `json:"removed"`
+	Changed []*SnapshotChange `json:"changed"`
+}
@@ -0,0 +1,15 @@
+package models
+
+//Cumulative statistics for one normalized statement, from pg_stat_statements
+//Action is the sqlcommenter action tag the statement carries (see store.tag), e.g. books.get
+type StatementStats struct {
+	QueryID        int64   `json:"query_id"`
+	Action         string  `json:"action"`
+	Query          string  `json:"query"`
+	Calls          int64   `json:"calls"`
+	TotalMs        float64 `json:"total_ms"`
+	MeanMs         float64 `json:"mean_ms"`
+	Rows           int64   `json:"rows"`
+	SharedBlksHit  int64   `json:"shared_blks_hit"`
+	SharedBlksRead int64   `json:"shared_blks_read"`
+}
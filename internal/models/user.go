@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+//Roles a user can have, see the roles table
+const (
+	RoleAdmin  = "admin"
+	RoleClerk  = "clerk"
+	RoleReader = "reader"
+)
+
+//An account that can log in. The password hash never leaves the store
+type User struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,51 @@
+//Package requestid gives each request an ID that follows it through our logs, error responses and SQL comments,
+//so a client's bug report or a proxy's log line can be matched with what the server did
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+//HTTP header (and, lower-cased, gRPC metadata key) the ID is read from and returned in
+const Header = "X-Request-ID"
+
+//The longest ID we accept from a client or proxy
+const maxLen = 128
+
+type key struct{}
+
+//A fresh random ID: 16 bytes, hex encoded
+func New() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//The ID to use for a request that arrived with id, e.g. from a proxy in front of us
+//It is kept if it is short and only letters, digits and - _ . : so it can't forge log lines or break a header;
+//otherwise, or when there is none, a new one is made
+func Use(id string) string {
+	if id == "" || len(id) > maxLen {
+		return New()
+	}
+	for _, c := range id {
+		ok := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':'
+		if !ok {
+			return New()
+		}
+	}
+	return id
+}
+
+//Attach id to ctx
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+//The ID attached to ctx, or "" if there is none
+func From(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
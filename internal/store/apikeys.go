@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/osmumos/bookstore/internal/models"
+)
+
+//Every key starts with this, so a leaked key is easy to recognise in logs and secret scanners
+const apiKeyPrefix = "bk_"
+
+const apiKeyColumns = "id, name, prefix, role, created_at, last_used_at, revoked_at"
+
+//Keys are stored as their SHA-256. They are 256 random bits, so unlike passwords they need no slow hash
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+//Timestamps are converted to UTC like those of books
+func scanAPIKey(s scanner, k *models.APIKey) error {
+	if err := s.Scan(&k.ID, &k.Name, &k.Prefix, &k.Role, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+		return err
+	}
+	k.CreatedAt = k.CreatedAt.UTC()
+	for _, t := range []**time.Time{&k.LastUsedAt, &k.RevokedAt} {
+		if *t != nil {
+			utc := (*t).UTC()
+			*t = &utc
+		}
+	}
+	return nil
+}
+
+//Issue a new key with the given role. The returned APIKey is the only place the secret ever appears
+func (s *Store) CreateAPIKey(ctx context.Context, name, role string) (*models.APIKey, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	k := new(models.APIKey)
+	err := scanAPIKey(s.db.QueryRowContext(ctx, tag(ctx, "apikeys.create",
+		"INSERT INTO api_keys (name, prefix, key_hash, role) VALUES ($1, $2, $3, $4) RETURNING "+apiKeyColumns),
+		name, key[:len(apiKeyPrefix)+8], hashAPIKey(key), role), k)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+		return nil, ErrUnknownRole
+	} else if err != nil {
+		return nil, err
+	}
+
+	k.Key = key
+	return k, nil
+}
+
+//All keys, revoked ones included, newest first
+func (s *Store) ListAPIKeys(ctx context.Context) ([]*models.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, tag(ctx, "apikeys.list", "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY created_at DESC"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]*models.APIKey, 0)
+	for rows.Next() {
+		k := new(models.APIKey)
+		if err := scanAPIKey(rows, k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+//Revoke the key with the given id. The row is kept, so its history stays visible
+//Returns ErrNotFound if there is no such key or it was already revoked
+func (s *Store) RevokeAPIKey(ctx context.Context, id string) (*models.APIKey, error) {
+	if !uuidPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+
+	k := new(models.APIKey)
+	err := scanAPIKey(s.db.QueryRowContext(ctx, tag(ctx, "apikeys.revoke",
+		"UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL RETURNING "+apiKeyColumns), id), k)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+//Look up a live key and record that it was used, in one statement
+//Returns ErrInvalidCredentials for an unknown or revoked key
+func (s *Store) AuthenticateAPIKey(ctx context.Context, key string) (*models.APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, ErrInvalidCredentials
+	}
+
+	k := new(models.APIKey)
+	err := scanAPIKey(s.db.QueryRowContext(ctx, tag(ctx, "apikeys.authenticate",
+		"UPDATE api_keys SET last_used_at = now() WHERE key_hash = $1 AND revoked_at IS NULL RETURNING "+apiKeyColumns),
+		hashAPIKey(key)), k)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
@@ -0,0 +1,331 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/osmumos/bookstore/internal/models"
+	"github.com/osmumos/bookstore/internal/validate"
+)
+
+//Returned by Create when a book with the same ISBN already exists
+var ErrBookExists = errors.New("a book with this ISBN already exists")
+
+//The operations on books the HTTP handlers need
+//Get, Update and Delete return ErrNotFound when no book has the given ISBN or UUID, Create ErrBookExists for a duplicate ISBN
+type BookStore interface {
+	List(ctx context.Context, f models.BookFilter, page, perPage int) (*models.BookPage, error)
+	Get(ctx context.Context, ref string, asOf time.Time) (*models.Book, error)
+	Create(ctx context.Context, in *models.Book) (*models.Book, error)
+	Update(ctx context.Context, ref string, in *models.Book) (before, after *models.Book, err error)
+	Delete(ctx context.Context, ref string) (int64, error)
+}
+
+//BookStore backed by the books and books_history tables
+type PostgresBooks struct {
+	db *sql.DB
+}
+
+var _ BookStore = (*PostgresBooks)(nil)
+
+//The Postgres BookStore sharing this Store's pool
+func (s *Store) Books() *PostgresBooks {
+	return &PostgresBooks{db: s.db}
+}
+
+//Columns selected for a Book, in the order scanBook expects them
+//List them explicitly rather than SELECT * so adding a column to the table doesn't break Scan
+const bookColumns = "id, isbn, title, author, price, created_at, updated_at"
+
+//Anything with a Scan method: *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+//bookColumns qualified with a table alias, e.g. old.id, old.isbn, ...
+func qualifiedBookColumns(alias string) string {
+	return alias + "." + strings.ReplaceAll(bookColumns, ", ", ", "+alias+".")
+}
+
+//Scan destinations for bookColumns
+func bookFields(bk *models.Book) []interface{} {
+	return []interface{}{&bk.ID, &bk.ISBN, &bk.Title, &bk.Author, &bk.Price, &bk.CreatedAt, &bk.UpdatedAt}
+}
+
+//Timestamps are converted to UTC so they are always rendered as RFC 3339 with a Z suffix
+func bookToUTC(bk *models.Book) {
+	bk.CreatedAt = bk.CreatedAt.UTC()
+	bk.UpdatedAt = bk.UpdatedAt.UTC()
+}
+
+func scanBook(s scanner, bk *models.Book) error {
+	if err := s.Scan(bookFields(bk)...); err != nil {
+		return err
+	}
+	bookToUTC(bk)
+	return nil
+}
+
+//Canonical textual UUID
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+//A book can be referred to by either identifier
+//Returns the column to match ref against, id when it is a UUID and isbn otherwise (an ISBN never looks like a UUID),
+//and the value to match. ISBNs are stored in canonical form, so 0-306-40615-2 and 9780306406157 both find 978-0306406157
+//The column is one of two fixed names, never user input, so it is safe to concatenate into SQL
+func bookRef(ref string) (column, value string) {
+	if uuidPattern.MatchString(ref) {
+		return "id", ref
+	}
+	if isbn, ok := validate.ISBN(ref); ok {
+		return "isbn", isbn
+	}
+	return "isbn", ref
+}
+
+//Escapes the LIKE wildcards % and _ (and the escape character itself) in user input
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+//Build the WHERE clause for f
+//Only the placeholder numbers are formatted into the SQL; the values themselves are returned as arguments
+func bookWhere(f models.BookFilter) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	for _, tf := range []struct {
+		cond string
+		t    time.Time
+	}{
+		{"created_at > $%d", f.CreatedAfter},
+		{"created_at < $%d", f.CreatedBefore},
+		{"updated_at > $%d", f.UpdatedAfter},
+		{"updated_at < $%d", f.UpdatedBefore},
+	} {
+		if !tf.t.IsZero() {
+			add(tf.cond, tf.t)
+		}
+	}
+
+	//ILIKE is a case-insensitive LIKE. Wildcards typed by the user are escaped so they match literally
+	if f.Title != "" {
+		add("title ILIKE $%d", "%"+likeEscaper.Replace(f.Title)+"%")
+	}
+	if f.Author != "" {
+		add("author ILIKE $%d", "%"+likeEscaper.Replace(f.Author)+"%")
+	}
+
+	switch {
+	case f.MinPrice != nil && f.MaxPrice != nil:
+		args = append(args, *f.MinPrice, *f.MaxPrice)
+		conds = append(conds, fmt.Sprintf("price BETWEEN $%d AND $%d", len(args)-1, len(args)))
+	case f.MinPrice != nil:
+		add("price >= $%d", *f.MinPrice)
+	case f.MaxPrice != nil:
+		add("price <= $%d", *f.MaxPrice)
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+//The query counting the books matching f
+//The queries are built apart from List and Get so the plans captured in explain.go are of exactly the SQL they run
+func countQuery(f models.BookFilter) (string, []interface{}) {
+	where, args := bookWhere(f)
+	return "SELECT count(*) FROM books" + where, args
+}
+
+//The query for one page of the books matching f
+func listQuery(f models.BookFilter, page, perPage int) (string, []interface{}) {
+	where, args := bookWhere(f)
+
+	//Pages need a stable order, otherwise rows can move between pages from one request to the next
+	query := fmt.Sprintf("SELECT %s FROM books%s ORDER BY isbn LIMIT $%d OFFSET $%d", bookColumns, where, len(args)+1, len(args)+2)
+	return query, append(args, perPage, (page-1)*perPage)
+}
+
+//The query for the book with the given ISBN or UUID, as it was at asOf unless that is zero
+func getQuery(ref string, asOf time.Time) (string, []interface{}) {
+	column, ref := bookRef(ref)
+
+	// Use Placeholder Parameters. Postgres uses $x while MySQL and MSSQL use ?
+	//Works for db.Query(), db.QueryRow() and db.Exec() to avoid SQL-Injection
+	if !asOf.IsZero() {
+		//The live row is the answer if it hasn't changed since asOf. Otherwise exactly one history row covers asOf,
+		//because each version's valid_to is the valid_from of the next. A book deleted before asOf is only in history
+		return "SELECT " + bookColumns + " FROM books WHERE " + column + " = $1 AND updated_at <= $2" +
+			" UNION ALL SELECT " + bookColumns + " FROM books_history WHERE " + column + " = $1 AND valid_from <= $2 AND valid_to > $2" +
+			" LIMIT 1", []interface{}{ref, asOf}
+	}
+	return "SELECT " + bookColumns + " FROM books WHERE " + column + " = $1", []interface{}{ref}
+}
+
+//Listing page size used when the client doesn't ask for one, and the most a client may ask for
+//A page may start at most MaxOffset books in: the database still reads every skipped row, and a huge page number would overflow the OFFSET
+const (
+	DefaultPerPage = 20
+	MaxPerPage     = 100
+	MaxOffset      = 1000000
+)
+
+//Returned by Paging for a page request it won't serve
+var ErrBadPage = fmt.Errorf("page and per_page must be positive integers, and the page must start within the first %d books", MaxOffset)
+
+//Check a page request for List. Every transport goes through here, so they all serve the same pages
+//0 stands for not given: page 1, of DefaultPerPage books. per_page above MaxPerPage is capped rather than rejected.
+//Negative numbers, and a page starting beyond MaxOffset, are ErrBadPage
+func Paging(page, perPage int) (int, int, error) {
+	if page < 0 || perPage < 0 {
+		return 0, 0, ErrBadPage
+	}
+	if page == 0 {
+		page = 1
+	}
+	if perPage == 0 {
+		perPage = DefaultPerPage
+	} else if perPage > MaxPerPage {
+		perPage = MaxPerPage
+	}
+
+	if page-1 > MaxOffset/perPage {
+		return 0, 0, ErrBadPage
+	}
+	return page, perPage, nil
+}
+
+//Fetch one page of the books matching f, plus the total number of matches
+//page and perPage must have come through Paging
+func (b *PostgresBooks) List(ctx context.Context, f models.BookFilter, page, perPage int) (*models.BookPage, error) {
+	//Count every matching row, not just this page, so clients can work out how many pages there are
+	var total int
+	query, args := countQuery(f)
+	if err := b.db.QueryRowContext(ctx, tag(ctx, "books.count", query), args...).Scan(&total); err != nil {
+		return nil, err
+	}
+
+	//Fetch a resultset and assign to a rows variable
+	query, args = listQuery(f, page, perPage)
+	rows, err := b.db.QueryContext(ctx, tag(ctx, "books.list", query), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	/*
+	** Important: Ensure resultset is closed properly before parent function returns.
+	** This ensures no hanging connections. The pool remains healthy.append
+	** Call defer after first checking for errors. Avoids getting a Panic when trying to close a nil resultset.
+	 */
+	defer rows.Close()
+
+	//An empty page encodes as [] rather than null because bks is made, not declared
+	bks := make([]*models.Book, 0)
+
+	//After reaching EOF, the resultset automatically closes itself and releases the connection back to the pool.
+	for rows.Next() {
+		bk := new(models.Book)
+
+		//Copy data from all the fields using scan into the bk object. Check for errors
+		if err := scanBook(rows, bk); err != nil {
+			return nil, err
+		}
+
+		//Add the new book to the books slice i.e. collection
+		bks = append(bks, bk)
+	}
+
+	//Check for any errors that might have occured during the interaction
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &models.BookPage{Books: bks, Page: page, PerPage: perPage, Total: total}, nil
+}
+
+//Fetch a single book by ISBN or UUID
+//A non-zero asOf returns the book as it was at that moment, from books_history
+func (b *PostgresBooks) Get(ctx context.Context, ref string, asOf time.Time) (*models.Book, error) {
+	action := "books.get"
+	if !asOf.IsZero() {
+		action = "books.get_as_of"
+	}
+	query, args := getQuery(ref, asOf)
+	row := b.db.QueryRowContext(ctx, tag(ctx, action, query), args...)
+
+	//If no rows were returned, the error will be thrown by row.Scan()
+	bk := new(models.Book)
+	if err := scanBook(row, bk); err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return bk, nil
+}
+
+//Insert a new book and return it as stored, with the id and timestamps the database assigned
+func (b *PostgresBooks) Create(ctx context.Context, in *models.Book) (*models.Book, error) {
+	//The sql.Result() interface exposes LastInsertedId() but PQ doesn't support it
+	//In Postgresql use RETURNING with QueryRow() instead of Exec() to get the generated columns back
+	bk := new(models.Book)
+	err := scanBook(b.db.QueryRowContext(ctx, tag(ctx, "books.create", "INSERT INTO books (isbn, title, author, price) VALUES($1, $2, $3, $4) RETURNING "+bookColumns),
+		in.ISBN, in.Title, in.Author, in.Price), bk)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return nil, ErrBookExists
+	} else if err != nil {
+		return nil, err
+	}
+	return bk, nil
+}
+
+//Replace the title, author and price of the book with the given ISBN or UUID
+//Returns the book as it was and as it is now. Both come from one statement, with the row locked in between,
+//so a concurrent update can't slip in and make before wrong
+func (b *PostgresBooks) Update(ctx context.Context, ref string, in *models.Book) (before, after *models.Book, err error) {
+	//RETURNING gives us both versions of the row; when no row matched, Scan reports sql.ErrNoRows
+	column, ref := bookRef(ref)
+	before, after = new(models.Book), new(models.Book)
+	err = b.db.QueryRowContext(ctx, tag(ctx, "books.update", "UPDATE books b SET title = $2, author = $3, price = $4"+
+		" FROM (SELECT "+bookColumns+" FROM books WHERE "+column+" = $1 FOR UPDATE) old WHERE b.id = old.id"+
+		" RETURNING "+qualifiedBookColumns("old")+", "+qualifiedBookColumns("b")),
+		ref, in.Title, in.Author, in.Price).Scan(append(bookFields(before), bookFields(after)...)...)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	bookToUTC(before)
+	bookToUTC(after)
+	return before, after, nil
+}
+
+//Delete the book with the given ISBN or UUID and report how many rows went
+func (b *PostgresBooks) Delete(ctx context.Context, ref string) (int64, error) {
+	// Use EXEC for Queries that don't return rows
+	column, ref := bookRef(ref)
+	result, err := b.db.ExecContext(ctx, tag(ctx, "books.delete", "DELETE FROM books WHERE "+column+" = $1"), ref)
+	if err != nil {
+		return 0, err
+	}
+
+	//The sql.Result() interface exposes RowsAffected(). Zero rows means there was no such book
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected == 0 {
+		return 0, ErrNotFound
+	}
+	return rowsAffected, nil
+}
@@ -0,0 +1,28 @@
+package store
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPaging(t *testing.T) {
+	for _, tc := range []struct {
+		page, perPage         int
+		wantPage, wantPerPage int
+		ok                    bool
+	}{
+		{0, 0, 1, DefaultPerPage, true},
+		{3, 50, 3, 50, true},
+		{2, 1000, 2, MaxPerPage, true},
+		{MaxOffset/MaxPerPage + 1, MaxPerPage, MaxOffset/MaxPerPage + 1, MaxPerPage, true},
+		{MaxOffset/MaxPerPage + 2, MaxPerPage, 0, 0, false},
+		{math.MaxInt32, 0, 0, 0, false},
+		{-1, 0, 0, 0, false},
+		{1, -1, 0, 0, false},
+	} {
+		page, perPage, err := Paging(tc.page, tc.perPage)
+		if page != tc.wantPage || perPage != tc.wantPerPage || (err == nil) != tc.ok {
+			t.Errorf("Paging(%d, %d) = %d, %d, %v", tc.page, tc.perPage, page, perPage, err)
+		}
+	}
+}
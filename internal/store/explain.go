@@ -0,0 +1,272 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/osmumos/bookstore/internal/models"
+	"github.com/osmumos/bookstore/internal/validate"
+)
+
+//Tables with at least this many (estimated) rows are flagged when a plan reads them with a sequential scan
+//Below it a Seq Scan is usually the cheapest plan anyway
+const largeTableRows = 10000
+
+//Returned by CapturePlan when an argument doesn't fit its parameter, e.g. an id that isn't a UUID
+var ErrBadArgument = errors.New("bad argument")
+
+//A query the server runs, registered under a name so its plan can be captured on demand
+//Params name the arguments given when the plan is captured. SQL is the statement as built for the example arguments;
+//which placeholders it has can depend on the arguments, e.g. book_as_of matches on id instead of isbn for a UUID
+type NamedQuery struct {
+	Name   string   `json:"name"`
+	Params []string `json:"params"`
+	SQL    string   `json:"sql"`
+
+	//Parse the arguments and build the statement with the same builder the store uses
+	build func(args []string) (string, []interface{}, error)
+}
+
+//Register a named query. example is a set of arguments for build, used to show its SQL
+func namedQuery(name string, params, example []string, build func(args []string) (string, []interface{}, error)) NamedQuery {
+	query, _, err := build(example)
+	if err != nil {
+		panic(fmt.Sprintf("named query %s: %v", name, err))
+	}
+	return NamedQuery{Name: name, Params: params, SQL: query, build: build}
+}
+
+//The hot queries, built by countQuery, listQuery and getQuery exactly as List and Get run them
+var namedQueries = []NamedQuery{
+	namedQuery("books_count", nil, nil, func(args []string) (string, []interface{}, error) {
+		query, params := countQuery(models.BookFilter{})
+		return query, params, nil
+	}),
+	namedQuery("books_page", []string{"page", "per_page"}, []string{"1", "20"}, func(args []string) (string, []interface{}, error) {
+		return pagedQuery(models.BookFilter{}, args[0], args[1])
+	}),
+	namedQuery("books_updated_after", []string{"updated_after", "page", "per_page"}, []string{"2024-01-01T00:00:00Z", "1", "20"},
+		func(args []string) (string, []interface{}, error) {
+			t, err := timeArg("updated_after", args[0])
+			if err != nil {
+				return "", nil, err
+			}
+			return pagedQuery(models.BookFilter{UpdatedAfter: t}, args[1], args[2])
+		}),
+	namedQuery("books_search_title", []string{"title", "page", "per_page"}, []string{"time", "1", "20"},
+		func(args []string) (string, []interface{}, error) {
+			return pagedQuery(models.BookFilter{Title: args[0]}, args[1], args[2])
+		}),
+	namedQuery("books_search_author", []string{"author", "page", "per_page"}, []string{"wells", "1", "20"},
+		func(args []string) (string, []interface{}, error) {
+			return pagedQuery(models.BookFilter{Author: args[0]}, args[1], args[2])
+		}),
+	namedQuery("book_by_isbn", []string{"isbn"}, []string{"978-1503261969"}, func(args []string) (string, []interface{}, error) {
+		if _, ok := validate.ISBN(args[0]); !ok {
+			return "", nil, fmt.Errorf("%w: isbn must be a valid ISBN-10 or ISBN-13", ErrBadArgument)
+		}
+		query, params := getQuery(args[0], time.Time{})
+		return query, params, nil
+	}),
+	namedQuery("book_by_id", []string{"id"}, []string{"00000000-0000-0000-0000-000000000000"}, func(args []string) (string, []interface{}, error) {
+		if !uuidPattern.MatchString(args[0]) {
+			return "", nil, fmt.Errorf("%w: id must be a UUID", ErrBadArgument)
+		}
+		query, params := getQuery(args[0], time.Time{})
+		return query, params, nil
+	}),
+	namedQuery("book_as_of", []string{"ref", "as_of"}, []string{"978-1503261969", "2024-01-01T00:00:00Z"},
+		func(args []string) (string, []interface{}, error) {
+			t, err := timeArg("as_of", args[1])
+			if err != nil {
+				return "", nil, err
+			}
+			query, params := getQuery(args[0], t)
+			return query, params, nil
+		}),
+}
+
+//Build listQuery for f from the page and per_page arguments
+func pagedQuery(f models.BookFilter, page, perPage string) (string, []interface{}, error) {
+	p, err := strconv.ParseInt(page, 10, 32)
+	if err != nil || p < 1 {
+		return "", nil, fmt.Errorf("%w: page must be a positive integer", ErrBadArgument)
+	}
+	pp, err := strconv.ParseInt(perPage, 10, 32)
+	if err != nil || pp < 1 {
+		return "", nil, fmt.Errorf("%w: per_page must be a positive integer", ErrBadArgument)
+	}
+	n, perN, err := Paging(int(p), int(pp))
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrBadArgument, err)
+	}
+	query, params := listQuery(f, n, perN)
+	return query, params, nil
+}
+
+//Parse the argument for the time parameter name
+func timeArg(name, v string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s must be an RFC 3339 time", ErrBadArgument, name)
+	}
+	return t, nil
+}
+
+//All queries whose plans can be captured
+func NamedQueries() []NamedQuery {
+	return namedQueries
+}
+
+//Look up a named query. Reports false if there is none by that name
+func LookupQuery(name string) (NamedQuery, bool) {
+	for _, q := range namedQueries {
+		if q.Name == name {
+			return q, true
+		}
+	}
+	return NamedQuery{}, false
+}
+
+//One node of EXPLAIN (FORMAT JSON) output, only the fields we look at
+type planNode struct {
+	NodeType     string     `json:"Node Type"`
+	RelationName string     `json:"Relation Name"`
+	Plans        []planNode `json:"Plans"`
+}
+
+//Run EXPLAIN (ANALYZE) on the named query with args, store the plan and return it
+//ANALYZE really executes the query, so it runs in a read-only transaction that is rolled back afterwards
+//Returns ErrNotFound for an unknown query name, and an ErrBadArgument for arguments that don't fit its parameters
+func (s *Store) CapturePlan(ctx context.Context, name string, args []string) (*models.QueryPlan, error) {
+	q, ok := LookupQuery(name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if len(args) != len(q.Params) {
+		return nil, fmt.Errorf("%w: query %s takes %d arguments, got %d", ErrBadArgument, name, len(q.Params), len(args))
+	}
+
+	query, params, err := q.build(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	err = func() error {
+		tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		return tx.QueryRowContext(ctx, tag(ctx, "admin.explain", "EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) "+query), params...).Scan(&out)
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	//FORMAT JSON yields a one-element array holding the plan tree and the timings
+	var explained []struct {
+		Plan          json.RawMessage `json:"Plan"`
+		PlanningTime  float64         `json:"Planning Time"`
+		ExecutionTime float64         `json:"Execution Time"`
+	}
+	if err := json.Unmarshal(out, &explained); err != nil || len(explained) != 1 {
+		return nil, fmt.Errorf("unexpected EXPLAIN output: %v", err)
+	}
+
+	var root planNode
+	if err := json.Unmarshal(explained[0].Plan, &root); err != nil {
+		return nil, err
+	}
+	seqScans, err := s.largeSeqScans(ctx, &root)
+	if err != nil {
+		return nil, err
+	}
+	flagged, err := json.Marshal(seqScans)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &models.QueryPlan{Query: name, Args: args, Plan: explained[0].Plan,
+		PlanningMs: explained[0].PlanningTime, ExecutionMs: explained[0].ExecutionTime, SeqScans: seqScans}
+	err = s.db.QueryRowContext(ctx, tag(ctx, "admin.save_plan", `INSERT INTO query_plans (query, args, plan, planning_ms, execution_ms, seq_scans)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, captured_at`),
+		name, pq.Array(args), []byte(p.Plan), p.PlanningMs, p.ExecutionMs, flagged).Scan(&p.ID, &p.CapturedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	p.CapturedAt = p.CapturedAt.UTC()
+	return p, nil
+}
+
+//Find the sequential scans in the plan tree rooted at root that read a table of at least largeTableRows rows
+//Row counts are the planner's estimates from pg_class, so they are only as fresh as the last ANALYZE of the table
+func (s *Store) largeSeqScans(ctx context.Context, root *planNode) ([]*models.SeqScan, error) {
+	var tables []string
+	var walk func(n *planNode)
+	walk = func(n *planNode) {
+		if n.NodeType == "Seq Scan" && n.RelationName != "" {
+			tables = append(tables, n.RelationName)
+		}
+		for i := range n.Plans {
+			walk(&n.Plans[i])
+		}
+	}
+	walk(root)
+
+	//An empty list encodes as [] rather than null
+	flagged := make([]*models.SeqScan, 0)
+	if len(tables) == 0 {
+		return flagged, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, tag(ctx, "admin.table_sizes", `SELECT relname, reltuples FROM pg_class
+		WHERE relkind = 'r' AND relname = ANY($1) AND reltuples >= $2 ORDER BY relname`), pq.Array(tables), largeTableRows)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		scan := new(models.SeqScan)
+		if err := rows.Scan(&scan.Table, &scan.EstimatedRows); err != nil {
+			return nil, err
+		}
+		flagged = append(flagged, scan)
+	}
+	return flagged, rows.Err()
+}
+
+//The most recently captured plans, newest first, optionally only those of one named query
+func (s *Store) ListPlans(ctx context.Context, name string, limit int) ([]*models.QueryPlan, error) {
+	rows, err := s.db.QueryContext(ctx, tag(ctx, "admin.list_plans", `SELECT id, query, args, plan, planning_ms, execution_ms, seq_scans, captured_at
+		FROM query_plans WHERE $1 = '' OR query = $1 ORDER BY id DESC LIMIT $2`), name, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := make([]*models.QueryPlan, 0)
+	for rows.Next() {
+		p := new(models.QueryPlan)
+		var plan, seqScans []byte
+		if err := rows.Scan(&p.ID, &p.Query, pq.Array(&p.Args), &plan, &p.PlanningMs, &p.ExecutionMs, &seqScans, &p.CapturedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(seqScans, &p.SeqScans); err != nil {
+			return nil, err
+		}
+		p.Plan = plan
+		p.CapturedAt = p.CapturedAt.UTC()
+		plans = append(plans, p)
+	}
+	return plans, rows.Err()
+}
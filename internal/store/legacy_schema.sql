@@ -0,0 +1,100 @@
+-- Bring a database created by hand from dbscripts/bookstore.sql, before there were migrations, to the
+-- schema of migration 5. That script changed over time: the first version had only books, keyed by isbn,
+-- with no id or timestamps. Every step here is skipped when the database already has it, so any version adopts.
+
+-- gen_random_uuid() is volatile, so every existing row gets its own id.
+ALTER TABLE books ADD COLUMN IF NOT EXISTS id uuid NOT NULL DEFAULT gen_random_uuid();
+ALTER TABLE books ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now();
+ALTER TABLE books ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL DEFAULT now();
+
+-- The first books table was keyed by isbn. Key it by id instead and keep isbn unique.
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_index i JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY (i.indkey)
+                 WHERE i.indrelid = 'books'::regclass AND i.indisprimary AND i.indnatts = 1 AND a.attname = 'id') THEN
+    ALTER TABLE books DROP CONSTRAINT IF EXISTS books_pkey;
+    ALTER TABLE books ADD PRIMARY KEY (id);
+  END IF;
+
+  IF NOT EXISTS (SELECT 1 FROM pg_index i JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY (i.indkey)
+                 WHERE i.indrelid = 'books'::regclass AND i.indisunique AND i.indnatts = 1 AND a.attname = 'isbn') THEN
+    ALTER TABLE books ADD UNIQUE (isbn);
+  END IF;
+END
+$$;
+
+CREATE OR REPLACE FUNCTION touch_updated_at() RETURNS trigger AS $$
+BEGIN
+  NEW.updated_at = now();
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS books_touch ON books;
+CREATE TRIGGER books_touch BEFORE UPDATE ON books
+  FOR EACH ROW EXECUTE PROCEDURE touch_updated_at();
+
+-- Migration 2
+CREATE TABLE IF NOT EXISTS books_history (
+  id         uuid NOT NULL,
+  isbn       char(14) NOT NULL,
+  title      varchar(255) NOT NULL,
+  author     varchar(255) NOT NULL,
+  price      decimal(5,2) NOT NULL,
+  created_at timestamptz NOT NULL,
+  updated_at timestamptz NOT NULL,
+  valid_from timestamptz NOT NULL,
+  valid_to   timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS books_history_id ON books_history (id, valid_from);
+CREATE INDEX IF NOT EXISTS books_history_isbn ON books_history (isbn, valid_from);
+
+CREATE OR REPLACE FUNCTION books_record_history() RETURNS trigger AS $$
+BEGIN
+  INSERT INTO books_history (id, isbn, title, author, price, created_at, updated_at, valid_from, valid_to)
+  VALUES (OLD.id, OLD.isbn, OLD.title, OLD.author, OLD.price, OLD.created_at, OLD.updated_at, OLD.updated_at, now());
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS books_history ON books;
+CREATE TRIGGER books_history AFTER UPDATE OR DELETE ON books
+  FOR EACH ROW EXECUTE PROCEDURE books_record_history();
+
+-- Migration 3
+CREATE TABLE IF NOT EXISTS healthcheck (
+  id      varchar(64) PRIMARY KEY,
+  payload text NOT NULL
+);
+
+-- Migration 4
+CREATE TABLE IF NOT EXISTS catalog_snapshots (
+  id         bigserial PRIMARY KEY,
+  label      varchar(255) NOT NULL DEFAULT '',
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS snapshot_books (
+  snapshot_id bigint NOT NULL REFERENCES catalog_snapshots (id) ON DELETE CASCADE,
+  book_id     uuid NOT NULL,
+  isbn        char(14) NOT NULL,
+  title       varchar(255) NOT NULL,
+  author      varchar(255) NOT NULL,
+  price       decimal(5,2) NOT NULL,
+  PRIMARY KEY (snapshot_id, book_id)
+);
+
+-- Migration 5
+CREATE TABLE IF NOT EXISTS query_plans (
+  id           bigserial PRIMARY KEY,
+  query        varchar(64) NOT NULL,
+  args         text[] NOT NULL,
+  plan         jsonb NOT NULL,
+  planning_ms  double precision NOT NULL,
+  execution_ms double precision NOT NULL,
+  seq_scans    jsonb NOT NULL,
+  captured_at  timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS query_plans_query ON query_plans (query, id);
@@ -0,0 +1,341 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//The schema, as versioned SQL files compiled into the binary
+//Each version has an up and a down file: NNNN_name.up.sql and NNNN_name.down.sql. Never edit a released one; add a new version
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+//Brings a books table made before there were migrations up to legacyVersion, see adoptLegacySchema
+//
+//go:embed legacy_schema.sql
+var legacySchema string
+
+//The schema version legacy_schema.sql leaves a database at: everything dbscripts/bookstore.sql ever created
+const legacyVersion = 5
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+//Advisory lock held while migrating. Any fixed number will do as long as nothing else in the database uses it;
+//keeping it below 2^32 means it shows up in pg_locks as objid with classid 0
+const migrationLockKey = 4263880417
+
+//What migrations run on: the pool, or the connection holding the migration lock. *sql.DB and *sql.Conn both fit
+type migrationDB interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+//One schema version
+type migration struct {
+	version  int64
+	name     string
+	up, down string
+}
+
+//Where a schema version stands in this database
+type MigrationStatus struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+//Read the embedded migrations in version order
+//Every version needs both an up and a down file, so a mistake in the tree fails here rather than halfway through a rollback
+func loadMigrations() ([]*migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*migration)
+	for _, e := range entries {
+		m := migrationName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migrations/%s: name must be NNNN_name.up.sql or NNNN_name.down.sql", e.Name())
+		}
+		version, _ := strconv.ParseInt(m[1], 10, 64)
+		body, err := migrationFiles.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &migration{version: version, name: m[2]}
+			byVersion[version] = mg
+		} else if mg.name != m[2] {
+			return nil, fmt.Errorf("migrations: version %d is used by both %s and %s", version, mg.name, m[2])
+		}
+		if m[3] == "up" {
+			mg.up = string(body)
+		} else {
+			mg.down = string(body)
+		}
+	}
+
+	migrations := make([]*migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if mg.up == "" || mg.down == "" {
+			return nil, fmt.Errorf("migrations: version %d (%s) needs both an up and a down file", mg.version, mg.name)
+		}
+		migrations = append(migrations, mg)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+//Create the table recording applied versions if this is a fresh database, and read what it holds
+func appliedMigrations(ctx context.Context, db migrationDB) (map[int64]time.Time, error) {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    bigint PRIMARY KEY,
+		name       varchar(255) NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at.UTC()
+	}
+	return applied, rows.Err()
+}
+
+//Run fn while holding the migration lock, so replicas starting together don't apply the same migration twice
+//The lock is a session advisory lock on a connection of its own, which Postgres releases if we die holding it.
+//fn gets that connection to migrate with, so even a pool of one connection can't deadlock.
+//A waiting instance logs who holds the lock and gives up after s.migrationLockTimeout
+func (s *Store) withMigrationLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(s.migrationLockTimeout)
+	for {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", migrationLockKey).Scan(&locked); err != nil {
+			return err
+		}
+		if locked {
+			break
+		}
+
+		holder := migrationLockHolder(ctx, conn)
+		if time.Now().After(deadline) {
+			return fmt.Errorf("gave up after %s waiting for the migration lock, held by %s", s.migrationLockTimeout, holder)
+		}
+		log.Printf("waiting for the migration lock, held by %s", holder)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+
+	//Unlock before the deferred Close: that only hands the connection back to the pool, lock and all
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	return fn(conn)
+}
+
+//Describe the session holding the migration lock, e.g. "pid 4242 (bookstore from 10.0.0.7, since 2024-05-01T10:00:00Z)"
+//Only used in messages, so a failure to find out is reported as unknown rather than as an error
+func migrationLockHolder(ctx context.Context, conn *sql.Conn) string {
+	var pid int
+	var app, client string
+	var since time.Time
+	err := conn.QueryRowContext(ctx, `SELECT a.pid, a.application_name, coalesce(host(a.client_addr), 'local socket'), a.backend_start
+		FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.granted AND l.classid = 0 AND l.objid = $1 AND l.objsubid = 1`, migrationLockKey).Scan(&pid, &app, &client, &since)
+	if err != nil {
+		return "an unknown session"
+	}
+	return fmt.Sprintf("pid %d (%s from %s, since %s)", pid, app, client, since.UTC().Format(time.RFC3339))
+}
+
+//Apply every migration not yet applied, oldest first, and return the versions applied
+//Each runs in its own transaction together with its schema_migrations row, so a failure leaves the schema at the last good version
+//Other instances wait while one migrates, then find nothing left to do
+func (s *Store) MigrateUp(ctx context.Context) (done []int64, err error) {
+	err = s.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		done, err = migrateUp(ctx, conn)
+		return err
+	})
+	return done, err
+}
+
+func migrateUp(ctx context.Context, db migrationDB) ([]int64, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if len(applied) == 0 {
+		if applied, err = adoptLegacySchema(ctx, db, migrations); err != nil {
+			return nil, err
+		}
+	}
+
+	var done []int64
+	for _, mg := range migrations {
+		if _, ok := applied[mg.version]; ok {
+			continue
+		}
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, mg.up); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", mg.version, mg.name)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s): %v", mg.version, mg.name, err)
+		}
+		log.Printf("migrated up to %d (%s)", mg.version, mg.name)
+		done = append(done, mg.version)
+	}
+	return done, nil
+}
+
+//Adopt a database created by hand from dbscripts/bookstore.sql, which has a books table but no recorded versions
+//legacy_schema.sql alters it into the shape of legacyVersion, whichever version of the script made it, and versions up to
+//legacyVersion are recorded as applied in the same transaction. Returns what is applied afterwards: nothing for an empty database
+func adoptLegacySchema(ctx context.Context, db migrationDB, migrations []*migration) (map[int64]time.Time, error) {
+	applied := make(map[int64]time.Time)
+
+	var legacy bool
+	if err := db.QueryRowContext(ctx, "SELECT to_regclass('books') IS NOT NULL").Scan(&legacy); err != nil || !legacy {
+		return applied, err
+	}
+
+	err := inTx(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, legacySchema); err != nil {
+			return err
+		}
+		for _, mg := range migrations {
+			if mg.version > legacyVersion {
+				break
+			}
+			if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", mg.version, mg.name); err != nil {
+				return err
+			}
+			applied[mg.version] = time.Now().UTC()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("adopting the existing books table: %v", err)
+	}
+	log.Printf("adopted the existing books table as schema version %d", legacyVersion)
+	return applied, nil
+}
+
+//Roll back the newest steps applied migrations, newest first, and return the versions rolled back
+func (s *Store) MigrateDown(ctx context.Context, steps int) (done []int64, err error) {
+	err = s.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		done, err = migrateDown(ctx, conn, steps)
+		return err
+	})
+	return done, err
+}
+
+func migrateDown(ctx context.Context, db migrationDB, steps int) ([]int64, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []int64
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		mg := migrations[i]
+		if _, ok := applied[mg.version]; !ok {
+			continue
+		}
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, mg.down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", mg.version)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s): %v", mg.version, mg.name, err)
+		}
+		log.Printf("migrated down from %d (%s)", mg.version, mg.name)
+		done = append(done, mg.version)
+	}
+	return done, nil
+}
+
+//Every known migration with the time it was applied, nil if it is pending
+func (s *Store) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, mg := range migrations {
+		status[i] = MigrationStatus{Version: mg.version, Name: mg.name}
+		if at, ok := applied[mg.version]; ok {
+			status[i].AppliedAt = &at
+		}
+	}
+	return status, nil
+}
+
+//Run fn in a transaction, committing if it returns nil and rolling back otherwise
+//Postgres DDL is transactional, so a failed migration leaves nothing half-created
+func inTx(ctx context.Context, db migrationDB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,2 @@
+DROP TABLE books;
+DROP FUNCTION touch_updated_at();
@@ -0,0 +1,30 @@
+-- id is the immutable surrogate key. gen_random_uuid() is built in from PostgreSQL 13
+-- (earlier versions need CREATE EXTENSION pgcrypto).
+CREATE TABLE books (
+  id      uuid NOT NULL DEFAULT gen_random_uuid(),
+  isbn    char(14) NOT NULL,
+  title   varchar(255) NOT NULL,
+  author  varchar(255) NOT NULL,
+  price   decimal(5,2) NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- Keep updated_at current on every UPDATE, whichever client makes it.
+CREATE FUNCTION touch_updated_at() RETURNS trigger AS $$
+BEGIN
+  NEW.updated_at = now();
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER books_touch BEFORE UPDATE ON books
+  FOR EACH ROW EXECUTE PROCEDURE touch_updated_at();
+
+INSERT INTO books (isbn, title, author, price) VALUES
+('978-1503261969', 'Emma', 'Jayne Austen', 9.44),
+('978-1505255607', 'The Time Machine', 'H. G. Wells', 5.99),
+('978-1503379640', 'The Prince', 'Niccolò Machiavelli', 6.99);
+
+ALTER TABLE books ADD PRIMARY KEY (id);
+ALTER TABLE books ADD UNIQUE (isbn);
@@ -0,0 +1,3 @@
+DROP TRIGGER books_history ON books;
+DROP FUNCTION books_record_history();
+DROP TABLE books_history;
@@ -0,0 +1,29 @@
+-- Every previous version of every book, for GET /books/{ref}?as_of=...
+-- A version was current from valid_from (inclusive) to valid_to (exclusive).
+-- Within one transaction now() is constant, so a replaced version's valid_to
+-- equals its successor's updated_at exactly and there are no gaps.
+CREATE TABLE books_history (
+  id         uuid NOT NULL,
+  isbn       char(14) NOT NULL,
+  title      varchar(255) NOT NULL,
+  author     varchar(255) NOT NULL,
+  price      decimal(5,2) NOT NULL,
+  created_at timestamptz NOT NULL,
+  updated_at timestamptz NOT NULL,
+  valid_from timestamptz NOT NULL,
+  valid_to   timestamptz NOT NULL
+);
+
+CREATE INDEX books_history_id ON books_history (id, valid_from);
+CREATE INDEX books_history_isbn ON books_history (isbn, valid_from);
+
+CREATE FUNCTION books_record_history() RETURNS trigger AS $$
+BEGIN
+  INSERT INTO books_history (id, isbn, title, author, price, created_at, updated_at, valid_from, valid_to)
+  VALUES (OLD.id, OLD.isbn, OLD.title, OLD.author, OLD.price, OLD.created_at, OLD.updated_at, OLD.updated_at, now());
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER books_history AFTER UPDATE OR DELETE ON books
+  FOR EACH ROW EXECUTE PROCEDURE books_record_history();
@@ -0,0 +1,5 @@
+-- Scratch table used by GET /selftest. Rows are deleted again by the check itself.
+CREATE TABLE healthcheck (
+  id      varchar(64) PRIMARY KEY,
+  payload text NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP TABLE snapshot_books;
+DROP TABLE catalog_snapshots;
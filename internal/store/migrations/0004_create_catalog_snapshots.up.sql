@@ -0,0 +1,17 @@
+-- Point-in-time copies of the catalog, see /snapshots. Rows are matched across
+-- snapshots by book_id, so an ISBN correction is reported as a change.
+CREATE TABLE catalog_snapshots (
+  id         bigserial PRIMARY KEY,
+  label      varchar(255) NOT NULL DEFAULT '',
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE snapshot_books (
+  snapshot_id bigint NOT NULL REFERENCES catalog_snapshots (id) ON DELETE CASCADE,
+  book_id     uuid NOT NULL,
+  isbn        char(14) NOT NULL,
+  title       varchar(255) NOT NULL,
+  author      varchar(255) NOT NULL,
+  price       decimal(5,2) NOT NULL,
+  PRIMARY KEY (snapshot_id, book_id)
+);
@@ -0,0 +1,14 @@
+-- EXPLAIN (ANALYZE) output captured through POST /admin/plans (ADMIN_ENABLED=true).
+-- seq_scans lists the large tables the plan read sequentially, i.e. index candidates.
+CREATE TABLE query_plans (
+  id           bigserial PRIMARY KEY,
+  query        varchar(64) NOT NULL,
+  args         text[] NOT NULL,
+  plan         jsonb NOT NULL,
+  planning_ms  double precision NOT NULL,
+  execution_ms double precision NOT NULL,
+  seq_scans    jsonb NOT NULL,
+  captured_at  timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX query_plans_query ON query_plans (query, id);
@@ -0,0 +1,8 @@
+-- Accounts that can log in with POST /login and then change the catalog.
+-- password_hash is bcrypt; add users with "bookstore adduser <username>".
+CREATE TABLE users (
+  id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  username      varchar(64) NOT NULL UNIQUE,
+  password_hash varchar(72) NOT NULL,
+  created_at    timestamptz NOT NULL DEFAULT now()
+);
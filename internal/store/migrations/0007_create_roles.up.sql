@@ -0,0 +1,17 @@
+-- Roles decide what a logged-in user may do:
+--   admin  - everything, including the /admin tools
+--   clerk  - change the catalog and take snapshots
+--   reader - nothing beyond the public read endpoints
+CREATE TABLE roles (
+  name        varchar(32) PRIMARY KEY,
+  description text NOT NULL
+);
+
+INSERT INTO roles (name, description) VALUES
+('admin', 'Everything, including the /admin tools'),
+('clerk', 'Change the catalog and take snapshots'),
+('reader', 'Read only');
+
+-- Existing users keep the power they had: before roles every user could write.
+ALTER TABLE users ADD COLUMN role varchar(32) NOT NULL DEFAULT 'clerk' REFERENCES roles (name);
+ALTER TABLE users ALTER COLUMN role SET DEFAULT 'reader';
@@ -0,0 +1,12 @@
+-- Keys for machine-to-machine clients, sent as X-API-Key. Only a SHA-256 of
+-- each key is kept; prefix is the start of the key, to tell keys apart.
+CREATE TABLE api_keys (
+  id           uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  name         varchar(255) NOT NULL,
+  prefix       varchar(16) NOT NULL,
+  key_hash     char(64) NOT NULL UNIQUE,
+  role         varchar(32) NOT NULL REFERENCES roles (name),
+  created_at   timestamptz NOT NULL DEFAULT now(),
+  last_used_at timestamptz,
+  revoked_at   timestamptz
+);
@@ -0,0 +1,4 @@
+-- This migration can't be reversed: the original spelling of each ISBN, in
+-- books, books_history and snapshot_books, isn't kept. The canonical form is
+-- still a valid value for those columns, so rolling back leaves it in place.
+SELECT 1;
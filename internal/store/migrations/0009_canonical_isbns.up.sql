@@ -0,0 +1,61 @@
+-- Since ISBNs are validated they are stored in one form: the ISBN-13 with a
+-- hyphen after the prefix, as in the seed data (ISBN-10s are converted). Bring
+-- older rows into that form, so the unique constraint catches the same book
+-- entered both ways and lookups, which canonicalize the ISBN, find them.
+-- Values that aren't valid ISBNs are left alone. If two rows turn out to be
+-- the same book the UPDATE fails; resolve the duplicate and migrate again.
+-- Mirrors validate.ISBN. Returns NULL for anything that isn't a valid ISBN.
+CREATE FUNCTION canonical_isbn(raw text) RETURNS text AS $$
+DECLARE
+  d     text := upper(translate(raw, '- ', ''));
+  want  int;
+  total int := 0;
+  c     int;
+BEGIN
+  IF d ~ '^[0-9]{9}[0-9X]$' THEN
+    FOR i IN 1..10 LOOP
+      c := CASE WHEN substr(d, i, 1) = 'X' THEN 10 ELSE substr(d, i, 1)::int END;
+      total := total + (11 - i) * c;
+    END LOOP;
+    IF total % 11 <> 0 THEN
+      RETURN NULL;
+    END IF;
+    d := '978' || left(d, 9);
+  ELSIF d ~ '^97[89][0-9]{10}$' THEN
+    want := right(d, 1)::int;
+    d := left(d, 12);
+  ELSE
+    RETURN NULL;
+  END IF;
+
+  total := 0;
+  FOR i IN 1..12 LOOP
+    total := total + substr(d, i, 1)::int * CASE WHEN i % 2 = 0 THEN 3 ELSE 1 END;
+  END LOOP;
+  c := (10 - total % 10) % 10;
+  IF want IS NOT NULL AND want <> c THEN
+    RETURN NULL;
+  END IF;
+  RETURN left(d, 3) || '-' || substr(d, 4) || c;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+-- A change of format isn't a new version of the book: keep updated_at and
+-- books_history as they are. History rows are rewritten too, so ?as_of= finds
+-- them by the canonical ISBN, and so are snapshot copies, or a diff between an
+-- older snapshot and a newer one (or the live catalog) would report every
+-- reformatted ISBN as a change.
+ALTER TABLE books DISABLE TRIGGER books_touch;
+ALTER TABLE books DISABLE TRIGGER books_history;
+
+UPDATE books SET isbn = canonical_isbn(isbn)
+  WHERE canonical_isbn(isbn) <> isbn::text;
+UPDATE books_history SET isbn = canonical_isbn(isbn)
+  WHERE canonical_isbn(isbn) <> isbn::text;
+UPDATE snapshot_books SET isbn = canonical_isbn(isbn)
+  WHERE canonical_isbn(isbn) <> isbn::text;
+
+ALTER TABLE books ENABLE TRIGGER books_touch;
+ALTER TABLE books ENABLE TRIGGER books_history;
+
+DROP FUNCTION canonical_isbn(text);
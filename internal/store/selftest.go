@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+//Outcome of one selftest step
+type SelftestStep struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Duration string `json:"duration,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+//End-to-end check of the database
+//Writes, reads back and deletes a row in the healthcheck table, so it proves more than a Ping can:
+//the schema is there, the DB user can write, and data round-trips intact. Reports false if any step failed
+func (s *Store) Selftest(ctx context.Context) ([]SelftestStep, bool) {
+	key := fmt.Sprintf("selftest-%d", time.Now().UnixNano())
+	payload := time.Now().UTC().Format(time.RFC3339Nano)
+
+	//If a step after the write fails, delete the row anyway so failed runs don't pile up in the table
+	//ctx may be what failed (the request budget ran out, or the client went away), so cleanup gets a context of its own
+	written, deleted := false, false
+	defer func() {
+		if !written || deleted {
+			return
+		}
+		cctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := s.db.ExecContext(cctx, tag(ctx, "selftest.cleanup", "DELETE FROM healthcheck WHERE id = $1"), key); err != nil {
+			log.Printf("selftest: removing healthcheck row %s: %v", key, err)
+		}
+	}()
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"write", func() error {
+			_, err := s.db.ExecContext(ctx, tag(ctx, "selftest.write", "INSERT INTO healthcheck (id, payload) VALUES ($1, $2)"), key, payload)
+			//An error may still have come after the row was inserted, e.g. a timeout waiting for the reply, so clean up regardless
+			written = true
+			return err
+		}},
+		{"read", func() error {
+			var got string
+			if err := s.db.QueryRowContext(ctx, tag(ctx, "selftest.read", "SELECT payload FROM healthcheck WHERE id = $1"), key).Scan(&got); err != nil {
+				return err
+			}
+			if got != payload {
+				return fmt.Errorf("read back %q, wrote %q", got, payload)
+			}
+			return nil
+		}},
+		{"delete", func() error {
+			result, err := s.db.ExecContext(ctx, tag(ctx, "selftest.delete", "DELETE FROM healthcheck WHERE id = $1"), key)
+			if err != nil {
+				return err
+			}
+			if n, err := result.RowsAffected(); err != nil || n != 1 {
+				return fmt.Errorf("expected 1 row deleted, got %d (%v)", n, err)
+			}
+			deleted = true
+			return nil
+		}},
+	}
+
+	//Stop at the first failure: later steps depend on earlier ones
+	var report []SelftestStep
+	for _, step := range steps {
+		start := time.Now()
+		if err := step.run(); err != nil {
+			report = append(report, SelftestStep{Name: step.name, Status: "failed", Error: err.Error()})
+			return report, false
+		}
+		report = append(report, SelftestStep{Name: step.name, Status: "ok", Duration: time.Since(start).Round(time.Millisecond).String()})
+	}
+	return report, true
+}
@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/osmumos/bookstore/internal/models"
+)
+
+//Copy the current catalog into a new snapshot
+func (s *Store) CreateSnapshot(ctx context.Context, label string) (*models.Snapshot, error) {
+	//Both statements run in one transaction, so the copy is exactly the catalog at one point in time
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	snap := &models.Snapshot{Label: label}
+	err = tx.QueryRowContext(ctx, tag(ctx, "snapshots.create", "INSERT INTO catalog_snapshots (label) VALUES ($1) RETURNING id, created_at"), label).
+		Scan(&snap.ID, &snap.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(ctx, tag(ctx, "snapshots.copy_books", `INSERT INTO snapshot_books (snapshot_id, book_id, isbn, title, author, price)
+		SELECT $1, id, isbn, title, author, price FROM books`), snap.ID)
+	if err != nil {
+		return nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	snap.Books = int(n)
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	snap.CreatedAt = snap.CreatedAt.UTC()
+	return snap, nil
+}
+
+//All snapshots, newest first
+func (s *Store) ListSnapshots(ctx context.Context) ([]*models.Snapshot, error) {
+	rows, err := s.db.QueryContext(ctx, tag(ctx, "snapshots.list", `SELECT s.id, s.label, s.created_at, count(b.book_id)
+		FROM catalog_snapshots s LEFT JOIN snapshot_books b ON b.snapshot_id = s.id
+		GROUP BY s.id ORDER BY s.id DESC`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snaps := make([]*models.Snapshot, 0)
+	for rows.Next() {
+		snap := new(models.Snapshot)
+		if err := rows.Scan(&snap.ID, &snap.Label, &snap.CreatedAt, &snap.Books); err != nil {
+			return nil, err
+		}
+		snap.CreatedAt = snap.CreatedAt.UTC()
+		snaps = append(snaps, snap)
+	}
+	return snaps, rows.Err()
+}
+
+//Compare snapshot from with snapshot to, or with the live catalog when to is nil
+//Returns ErrNotFound if either snapshot doesn't exist, otherwise a typo would look like "everything was added"
+func (s *Store) DiffSnapshots(ctx context.Context, from int64, to *int64) (*models.SnapshotDiff, error) {
+	diff := &models.SnapshotDiff{From: from, To: to,
+		Added: []*models.SnapshotBook{}, Removed: []*models.SnapshotBook{}, Changed: []*models.SnapshotChange{}}
+
+	//The right-hand side is either another snapshot or the books table itself
+	ids := []int64{from}
+	args := []interface{}{from}
+	right := "SELECT id AS book_id, isbn, title, author, price FROM books"
+	if to != nil {
+		if *to != from {
+			ids = append(ids, *to)
+		}
+		args = append(args, *to)
+		right = "SELECT book_id, isbn, title, author, price FROM snapshot_books WHERE snapshot_id = $2"
+	}
+
+	var found int
+	if err := s.db.QueryRowContext(ctx, tag(ctx, "snapshots.exist", "SELECT count(*) FROM catalog_snapshots WHERE id = ANY($1)"), pq.Array(ids)).Scan(&found); err != nil {
+		return nil, err
+	}
+	if found != len(ids) {
+		return nil, ErrNotFound
+	}
+
+	//A FULL JOIN keeps books missing on either side; unchanged books are filtered out in SQL
+	rows, err := s.db.QueryContext(ctx, tag(ctx, "snapshots.diff", `SELECT coalesce(a.book_id, b.book_id),
+			a.isbn, a.title, a.author, a.price, b.isbn, b.title, b.author, b.price
+		FROM (SELECT book_id, isbn, title, author, price FROM snapshot_books WHERE snapshot_id = $1) a
+		FULL JOIN (`+right+`) b ON a.book_id = b.book_id
+		WHERE a.book_id IS NULL OR b.book_id IS NULL
+			OR (a.isbn, a.title, a.author, a.price) IS DISTINCT FROM (b.isbn, b.title, b.author, b.price)
+		ORDER BY coalesce(b.isbn, a.isbn)`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		//Columns from the side a book is missing on are NULL, so scan into sql.NullString and sql.NullFloat64
+		var id string
+		var before, after nullableSnapshotBook
+		if err := rows.Scan(&id, &before.isbn, &before.title, &before.author, &before.price,
+			&after.isbn, &after.title, &after.author, &after.price); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case !before.isbn.Valid:
+			diff.Added = append(diff.Added, after.book(id))
+		case !after.isbn.Valid:
+			diff.Removed = append(diff.Removed, before.book(id))
+		default:
+			diff.Changed = append(diff.Changed, &models.SnapshotChange{ID: id, Before: before.book(id), After: after.book(id)})
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+type nullableSnapshotBook struct {
+	isbn, title, author sql.NullString
+	price               sql.NullFloat64
+}
+
+func (n nullableSnapshotBook) book(id string) *models.SnapshotBook {
+	return &models.SnapshotBook{ID: id, ISBN: n.isbn.String, Title: n.title.String, Author: n.author.String, Price: n.price.Float64}
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/lib/pq"
+	"github.com/osmumos/bookstore/internal/models"
+)
+
+//Returned when pg_stat_statements is not installed in the database or not loaded by the server
+var ErrStatementsUnavailable = errors.New("pg_stat_statements is not available")
+
+//Orderings accepted by TopStatements, mapped to the column they sort on
+//The values are fixed column names, never user input, so they are safe to concatenate into SQL
+var statementOrders = map[string]string{
+	"total_time": "total_exec_time",
+	"mean_time":  "mean_exec_time",
+	"calls":      "calls",
+}
+
+//Reports whether order is one TopStatements understands
+func ValidStatementOrder(order string) bool {
+	_, ok := statementOrders[order]
+	return ok
+}
+
+var actionTag = regexp.MustCompile(`/\*action='([^']*)'`)
+
+//The limit statements of this application with the highest total time, mean time or calls
+//"This application" means statements run by our database user in our database that carry our sqlcommenter tag
+//Needs PostgreSQL 13 or later (for the *_exec_time columns) with pg_stat_statements in shared_preload_libraries
+//and CREATE EXTENSION pg_stat_statements run in the database; otherwise it returns ErrStatementsUnavailable
+func (s *Store) TopStatements(ctx context.Context, order string, limit int) ([]*models.StatementStats, error) {
+	column, ok := statementOrders[order]
+	if !ok {
+		column = statementOrders["total_time"]
+	}
+
+	var installed bool
+	err := s.db.QueryRowContext(ctx, tag(ctx, "admin.statements_installed",
+		"SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_stat_statements')")).Scan(&installed)
+	if err != nil {
+		return nil, err
+	}
+	if !installed {
+		return nil, ErrStatementsUnavailable
+	}
+
+	rows, err := s.db.QueryContext(ctx, tag(ctx, "admin.statements", `SELECT queryid, query, calls, total_exec_time, mean_exec_time,
+			rows, shared_blks_hit, shared_blks_read
+		FROM pg_stat_statements
+		WHERE dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+			AND userid = (SELECT oid FROM pg_roles WHERE rolname = current_user)
+			AND query LIKE '%/*action=%'
+		ORDER BY `+column+` DESC LIMIT $1`), limit)
+	if err != nil {
+		//The view exists but the library isn't preloaded: "must be loaded via shared_preload_libraries"
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "55000" {
+			return nil, ErrStatementsUnavailable
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make([]*models.StatementStats, 0)
+	for rows.Next() {
+		st := new(models.StatementStats)
+		if err := rows.Scan(&st.QueryID, &st.Query, &st.Calls, &st.TotalMs, &st.MeanMs,
+			&st.Rows, &st.SharedBlksHit, &st.SharedBlksRead); err != nil {
+			return nil, err
+		}
+		if m := actionTag.FindStringSubmatch(st.Query); m != nil {
+			st.Action = m[1]
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
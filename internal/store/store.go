@@ -0,0 +1,98 @@
+//Package store runs every query against the Postgres database
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/osmumos/bookstore/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+
+	//Alias the Package Name to the Blank Identifier (_) so that its pq.init() is called to register itself with database/sql
+	//But we cannot use it directly
+	_ "github.com/lib/pq"
+)
+
+//Returned when the book or snapshot asked for doesn't exist
+var ErrNotFound = errors.New("not found")
+
+//Store wraps the connection pool. It is safe for concurrent use, like the *sql.DB inside it
+type Store struct {
+	db *sql.DB
+
+	//How long MigrateUp and MigrateDown wait for another instance's migration to finish
+	migrationLockTimeout time.Duration
+}
+
+//Connect to the database described by cfg
+//The database may still be starting when we boot, so Open keeps retrying for cfg.StartupRetryWindow before giving up
+func Open(cfg *config.Config) (*Store, error) {
+	//Initialize a new sql.DB object by calling sql.Open(). pass in name of the driver
+	//The Pool size is changed using the returned db object e.g. db.SetMaxOpenConns() and db.SetMaxIdleConns()
+	//application_name lets DBAs tell our connections apart in pg_stat_activity
+	dsn, err := withApplicationName(cfg.DatabaseURL, cfg.ApplicationName)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	//Check the Connection using db.Ping() because sql.Open() doesn't check whether the connection is open
+	s := &Store{db: db, migrationLockTimeout: cfg.MigrationLockTimeout}
+	if err = s.waitForDB(cfg.StartupRetryWindow); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+//Ping the database with exponential backoff until it answers or the window has passed
+func (s *Store) waitForDB(window time.Duration) error {
+	deadline := time.Now().Add(window)
+	backoff := 250 * time.Millisecond
+
+	for {
+		//Bound each attempt too, otherwise a single Ping to an unreachable host could outlast the whole window
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := s.db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("database not reachable after %s: %v", window, err)
+		}
+
+		log.Printf("database not ready, retrying in %s: %v", backoff, err)
+		time.Sleep(backoff)
+
+		if backoff *= 2; backoff > 5*time.Second {
+			backoff = 5 * time.Second
+		}
+	}
+}
+
+//Check the database is reachable, for readiness probes
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+//Close the pool. Call it only after the HTTP server has finished its in-flight requests
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+//Prometheus collector for the connection pool: open, in use and idle connections, waits and closed connections
+func (s *Store) Collector() prometheus.Collector {
+	return collectors.NewDBStatsCollector(s.db, "bookstore")
+}
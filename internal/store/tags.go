@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/osmumos/bookstore/internal/requestid"
+)
+
+type routeKey struct{}
+
+//Record the HTTP route serving ctx, e.g. "GET /books/{ref}", so queries run with ctx are tagged with it
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+//Append a sqlcommenter-style comment to query, e.g. SELECT ... /*action='books.get',route='GET%20%2Fv1%2Fbooks%2F%7Bref%7D'*/
+//DBAs see it in pg_stat_activity and the slow query log and can map a statement back to the endpoint that ran it,
+//and with request_id back to the very request
+//Values are percent-encoded, so nothing in them can close the comment early
+func tag(ctx context.Context, action, query string) string {
+	tags := map[string]string{"action": action}
+	if route, ok := ctx.Value(routeKey{}).(string); ok && route != "" {
+		tags["route"] = route
+	}
+	if id := requestid.From(ctx); id != "" {
+		tags["request_id"] = id
+	}
+
+	//The sqlcommenter spec wants the keys sorted
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "='" + url.PathEscape(tags[k]) + "'"
+	}
+	return query + " /*" + strings.Join(pairs, ",") + "*/"
+}
+
+//Add application_name to the connection string unless it already sets one
+//Postgres shows it in pg_stat_activity and can log it with log_line_prefix %a
+//Both the URL form (postgres://...) and the key=value form are understood
+func withApplicationName(dsn, name string) (string, error) {
+	if name == "" || strings.Contains(dsn, "application_name") {
+		return dsn, nil
+	}
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		q.Set("application_name", name)
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+
+	//In the key=value form values with spaces or quotes are single-quoted, with \ escaping
+	return dsn + " application_name='" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(name) + "'", nil
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/osmumos/bookstore/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	//Returned by Authenticate for an unknown username or a wrong password, deliberately without saying which
+	ErrInvalidCredentials = errors.New("invalid username or password")
+
+	//Returned by CreateUser when the username is taken
+	ErrUserExists = errors.New("username already exists")
+
+	//Returned by CreateUser for a role that isn't in the roles table
+	ErrUnknownRole = errors.New("unknown role")
+)
+
+//Compared against when the username doesn't exist, so an unknown user takes as long to reject as a wrong password
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+//Add a user with the given role, storing only a bcrypt hash of the password
+func (s *Store) CreateUser(ctx context.Context, username, password, role string) (*models.User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &models.User{Username: username, Role: role}
+	err = s.db.QueryRowContext(ctx, tag(ctx, "users.create", "INSERT INTO users (username, password_hash, role) VALUES ($1, $2, $3) RETURNING id, created_at"),
+		username, string(hash), role).Scan(&u.ID, &u.CreatedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return nil, ErrUserExists
+	} else if ok && pqErr.Code == "23503" {
+		return nil, ErrUnknownRole
+	} else if err != nil {
+		return nil, err
+	}
+
+	u.CreatedAt = u.CreatedAt.UTC()
+	return u, nil
+}
+
+//Check a username and password, returning the user they belong to or ErrInvalidCredentials
+func (s *Store) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	u := &models.User{Username: username}
+	var hash string
+	err := s.db.QueryRowContext(ctx, tag(ctx, "users.authenticate", "SELECT id, password_hash, role, created_at FROM users WHERE username = $1"),
+		username).Scan(&u.ID, &hash, &u.Role, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	u.CreatedAt = u.CreatedAt.UTC()
+	return u, nil
+}
@@ -0,0 +1,108 @@
+//Package systemd integrates with systemd: socket activation, sd_notify and the watchdog
+//Everything degrades to a no-op (or a plain listener) when we were not started by systemd
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+//Send a state string such as "READY=1" to systemd
+//Does nothing when NOTIFY_SOCKET is unset, i.e. when we were not started by systemd with Type=notify
+func Notify(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+
+	//A leading @ denotes a socket in the abstract namespace
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		log.Printf("sd_notify: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte(state)); err != nil {
+		log.Printf("sd_notify: %v", err)
+	}
+}
+
+//Ping the systemd watchdog at half the configured interval
+//systemd sets WATCHDOG_USEC when the unit has WatchdogSec=, and restarts us if the pings stop
+func Watchdog() {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	for range time.Tick(time.Duration(usec) * time.Microsecond / 2) {
+		Notify("WATCHDOG=1")
+	}
+}
+
+//Open the listening socket
+//When started by systemd socket activation the socket is inherited instead, so systemd keeps accepting
+//connections while the binary is replaced during a deploy and the new process picks them up
+//A non-empty unixSocket path is served instead of addr, for a reverse proxy on the same host
+func Listen(addr, unixSocket string) (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		if unixSocket != "" {
+			if err := removeStaleSocket(unixSocket); err != nil {
+				return nil, err
+			}
+			return net.Listen("unix", unixSocket)
+		}
+		return net.Listen("tcp", addr)
+	}
+
+	//The variables are meant for us alone; a child process that inherited them would try to use fd 3 as well
+	defer func() {
+		for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			os.Unsetenv(name)
+		}
+	}()
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("socket activation: invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+
+	//Inherited sockets start at file descriptor 3 (SD_LISTEN_FDS_START). We only serve on the first one
+	f := os.NewFile(3, "systemd-socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+//A socket file left behind by a previous run would make Listen fail with "address already in use", so remove it
+//The file is only stale if nothing accepts connections on it: a server still running there gets an error instead
+//of having its socket taken over. Anything but a socket at the path is a mistake in the configuration, and Listen reports it
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("listen unix %s: address already in use by a running server", path)
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return os.Remove(path)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//The socket of a server that is still running is left alone
+func TestListenSocketInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bookstore.sock")
+	live, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+
+	if ln, err := Listen("", path); err == nil {
+		ln.Close()
+		t.Fatal("Listen took over the socket of a running server")
+	}
+
+	//The running server still gets connections
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("socket of the running server was removed: %v", err)
+	}
+	conn.Close()
+}
+
+//A socket left behind by a server that has gone is replaced
+func TestListenStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bookstore.sock")
+	old, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.(*net.UnixListener).SetUnlinkOnClose(false)
+	old.Close()
+	if _, err := os.Lstat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := Listen("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+}
+
+//Anything but a socket at the path is not removed
+func TestListenNotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bookstore.sock")
+	if err := os.WriteFile(path, []byte("keep me"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if ln, err := Listen("", path); err == nil {
+		ln.Close()
+		t.Fatal("Listen replaced a regular file")
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "keep me" {
+		t.Fatalf("file was changed: %q, %v", b, err)
+	}
+}
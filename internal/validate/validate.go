@@ -0,0 +1,137 @@
+//Package validate checks book input before it reaches the store, and says what is wrong with each field
+//The HTTP handlers and the gRPC server share it, so both accept exactly the same books
+package validate
+
+import (
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/osmumos/bookstore/internal/models"
+)
+
+//Longest title or author the books table holds (varchar(255)), and the longest snapshot label or API key name
+const MaxTextLen = 255
+
+//Largest price the books table holds (decimal(5,2))
+const MaxPrice = 999.99
+
+//What is wrong with one field, e.g. {"name":"isbn","reason":"must be a valid ISBN-10 or ISBN-13"}
+type FieldError struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+//Everything wrong with an input, in field order. Empty means valid
+type Errors []FieldError
+
+//Record that field name is wrong for reason
+func (e *Errors) Add(name, reason string) {
+	*e = append(*e, FieldError{Name: name, Reason: reason})
+}
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Name + " " + fe.Reason
+	}
+	return strings.Join(msgs, "; ")
+}
+
+//Check a book about to be created (withISBN) or updated, and tidy it for storing
+//Title and author are trimmed, and the ISBN is put in canonical form, see ISBN
+func Book(bk *models.Book, withISBN bool) Errors {
+	var errs Errors
+
+	if withISBN {
+		if bk.ISBN == "" {
+			errs.Add("isbn", "is required")
+		} else if isbn, ok := ISBN(bk.ISBN); !ok {
+			errs.Add("isbn", "must be a valid ISBN-10 or ISBN-13")
+		} else {
+			bk.ISBN = isbn
+		}
+	}
+
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{
+		{"title", &bk.Title},
+		{"author", &bk.Author},
+	} {
+		*f.value = strings.TrimSpace(*f.value)
+		if *f.value == "" {
+			errs.Add(f.name, "is required")
+		} else if utf8.RuneCountInString(*f.value) > MaxTextLen {
+			errs.Add(f.name, "must be at most 255 characters")
+		}
+	}
+
+	price := float64(bk.Price)
+	switch {
+	case math.IsNaN(price) || math.IsInf(price, 0):
+		errs.Add("price", "must be a number")
+	case price < 0:
+		errs.Add("price", "must not be negative")
+	case price > MaxPrice:
+		errs.Add("price", "must be at most 999.99")
+	}
+
+	return errs
+}
+
+//Check an ISBN-10 or ISBN-13, hyphens and spaces allowed, and return it in canonical form
+//The canonical form is the ISBN-13 with a hyphen after the prefix, e.g. 978-1503261969, as in the seed data;
+//an ISBN-10 is converted, so one book can't be stored twice under its two numbers. Reports false if the check digit is wrong
+func ISBN(s string) (string, bool) {
+	digits := strings.NewReplacer("-", "", " ", "").Replace(s)
+
+	switch len(digits) {
+	case 10:
+		//Weights 10 down to 1; the check digit may be X for 10
+		sum := 0
+		for i, c := range digits {
+			d := int(c - '0')
+			if i == 9 && (c == 'X' || c == 'x') {
+				d = 10
+			} else if c < '0' || c > '9' {
+				return "", false
+			}
+			sum += (10 - i) * d
+		}
+		if sum%11 != 0 {
+			return "", false
+		}
+		digits = "978" + digits[:9]
+		return digits[:3] + "-" + digits[3:] + string(rune('0'+isbn13Check(digits))), true
+
+	case 13:
+		for _, c := range digits {
+			if c < '0' || c > '9' {
+				return "", false
+			}
+		}
+		if !strings.HasPrefix(digits, "978") && !strings.HasPrefix(digits, "979") {
+			return "", false
+		}
+		if isbn13Check(digits[:12]) != int(digits[12]-'0') {
+			return "", false
+		}
+		return digits[:3] + "-" + digits[3:], true
+	}
+	return "", false
+}
+
+//The ISBN-13 check digit for its first 12 digits: weights alternate 1 and 3, and the total must be a multiple of 10
+func isbn13Check(first12 string) int {
+	sum := 0
+	for i, c := range first12[:12] {
+		d := int(c - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10 - sum%10) % 10
+}
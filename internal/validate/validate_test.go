@@ -0,0 +1,108 @@
+package validate
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/osmumos/bookstore/internal/models"
+)
+
+func TestISBN(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		//ISBN-13, with and without hyphens
+		{"978-1503261969", "978-1503261969", true},
+		{"9781503261969", "978-1503261969", true},
+		{"978-1-5032-6196-9", "978-1503261969", true},
+		{"978 1503261969", "978-1503261969", true},
+		{"979-1090636071", "979-1090636071", true},
+		{"979-10-90636-07-1", "979-1090636071", true},
+
+		//ISBN-10s are converted to ISBN-13
+		{"0-306-40615-2", "978-0306406157", true},
+		{"0306406152", "978-0306406157", true},
+		{"080442957X", "978-0804429573", true},
+		{"080442957x", "978-0804429573", true},
+
+		//Wrong check digits
+		{"978-1503261968", "", false},
+		{"979-1090636072", "", false},
+		{"0-306-40615-3", "", false},
+		{"0804429579", "", false},
+
+		//Neither an ISBN-10 nor an ISBN-13
+		{"", "", false},
+		{"12345", "", false},
+		{"977-1503261969", "", false},
+		{"X804429570", "", false},
+		{"97815032619690", "", false},
+		{"978-150326196a", "", false},
+	} {
+		got, ok := ISBN(tc.in)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("ISBN(%q) = %q, %v; want %q, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestBook(t *testing.T) {
+	valid := func() *models.Book {
+		return &models.Book{ISBN: "0-306-40615-2", Title: "Emma", Author: "Jane Austen", Price: 9.44}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		edit     func(bk *models.Book)
+		withISBN bool
+		want     Errors
+	}{
+		{"valid", func(bk *models.Book) {}, true, nil},
+		{"update ignores isbn", func(bk *models.Book) { bk.ISBN = "" }, false, nil},
+		{"missing isbn", func(bk *models.Book) { bk.ISBN = "" }, true, Errors{{"isbn", "is required"}}},
+		{"bad isbn", func(bk *models.Book) { bk.ISBN = "978-1503261968" }, true, Errors{{"isbn", "must be a valid ISBN-10 or ISBN-13"}}},
+		{"blank title", func(bk *models.Book) { bk.Title = "  " }, true, Errors{{"title", "is required"}}},
+		{"255 rune title", func(bk *models.Book) { bk.Title = strings.Repeat("é", 255) }, true, nil},
+		{"256 rune title", func(bk *models.Book) { bk.Title = strings.Repeat("é", 256) }, true, Errors{{"title", "must be at most 255 characters"}}},
+		{"256 rune author", func(bk *models.Book) { bk.Author = strings.Repeat("a", 256) }, true, Errors{{"author", "must be at most 255 characters"}}},
+		{"free", func(bk *models.Book) { bk.Price = 0 }, true, nil},
+		{"highest price", func(bk *models.Book) { bk.Price = 999.99 }, true, nil},
+		{"price too high", func(bk *models.Book) { bk.Price = 1000 }, true, Errors{{"price", "must be at most 999.99"}}},
+		{"negative price", func(bk *models.Book) { bk.Price = -0.01 }, true, Errors{{"price", "must not be negative"}}},
+		{"NaN price", func(bk *models.Book) { bk.Price = float32(math.NaN()) }, true, Errors{{"price", "must be a number"}}},
+		{"infinite price", func(bk *models.Book) { bk.Price = float32(math.Inf(1)) }, true, Errors{{"price", "must be a number"}}},
+		{"everything wrong", func(bk *models.Book) { *bk = models.Book{ISBN: "x", Price: -1} }, true, Errors{
+			{"isbn", "must be a valid ISBN-10 or ISBN-13"},
+			{"title", "is required"},
+			{"author", "is required"},
+			{"price", "must not be negative"},
+		}},
+	} {
+		bk := valid()
+		tc.edit(bk)
+		got := Book(bk, tc.withISBN)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestBookTidies(t *testing.T) {
+	bk := &models.Book{ISBN: "0306406152", Title: "  Emma ", Author: "\tJane Austen\n", Price: 9.44}
+	if errs := Book(bk, true); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if bk.ISBN != "978-0306406157" || bk.Title != "Emma" || bk.Author != "Jane Austen" {
+		t.Errorf("got %+v", bk)
+	}
+}
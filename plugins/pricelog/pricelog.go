@@ -0,0 +1,18 @@
+//Package pricelog is an example plugin: it logs every price change
+//Build the server with -tags pricelog to enable it
+package pricelog
+
+import (
+	"context"
+	"log"
+
+	"github.com/osmumos/bookstore/internal/hooks"
+	"github.com/osmumos/bookstore/internal/models"
+)
+
+func init() {
+	hooks.Default.OnPriceChanged(func(ctx context.Context, before, after *models.Book) error {
+		log.Printf("price of %s (%s) changed from %.2f to %.2f", after.ISBN, after.Title, before.Price, after.Price)
+		return nil
+	})
+}